@@ -0,0 +1,15 @@
+// Command baaah generates the CRDs, deepcopy funcs, and RBAC and webhook manifests of a project with
+//
+//	baaah gen <crd|rbac|deepcopy|webhook|all>[,...] [-output-dir dir] [-role-name name] <packages...>
+//
+// The generated ClusterRole does not include the rules of a router, because this command has no router. Run gen.Main
+// with Options.Router from the command of your project to include them.
+package main
+
+import (
+	"github.com/acorn-io/baaah/pkg/gen"
+)
+
+func main() {
+	gen.Main(gen.Options{})
+}
@@ -1,3 +1,6 @@
+// Command deepcopy generates the deepcopy funcs of the packages given as arguments.
+//
+// Deprecated: use baaah gen deepcopy, which also generates CRDs, RBAC, and webhook manifests.
 package main
 
 import (
@@ -0,0 +1,293 @@
+package apiserver
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/acorn-io/baaah/pkg/log"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+	certutil "k8s.io/client-go/util/cert"
+	"k8s.io/kube-openapi/pkg/common"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+const (
+	defaultPort = 7443
+)
+
+type Options struct {
+	// Scheme must include the types of all resources and their lists. Required.
+	Scheme *runtime.Scheme
+	// Port is the port the server listens on. Defaults to 7443.
+	Port int
+	// TLSConfig is the TLS configuration of the server. If nil, a self-signed certificate is generated on start, and
+	// the APIService must then skip the verification of the server. Its ClientCAs and ClientAuth are replaced to verify
+	// the client certificate of the front proxy of the Kubernetes API server unless InsecureSkipAuth is set.
+	TLSConfig *tls.Config
+	// Client reads the front proxy configuration from the ConfigMap kube-system/extension-apiserver-authentication when
+	// the server starts and creates a SubjectAccessReview for each request. Its scheme must include the core and
+	// authorization v1 types, and it should not cache, as only that ConfigMap is read. Required unless InsecureSkipAuth
+	// is set.
+	Client kclient.Client
+	// InsecureSkipAuth serves requests without authenticating or authorizing them. The server must then only be
+	// reachable by the Kubernetes API server.
+	InsecureSkipAuth bool
+	// OpenAPIDefinitions are the definitions generated by openapi-gen for the types of the resources and the types they
+	// reference, such as those of k8s.io/apimachinery. The OpenAPI document is not served if nil.
+	OpenAPIDefinitions common.GetOpenAPIDefinitions
+	// Title is the title of the OpenAPI document. Defaults to "baaah".
+	Title string
+	// Version is the version of the OpenAPI document. Defaults to "unversioned".
+	Version string
+}
+
+func (o Options) complete() (Options, error) {
+	if o.Scheme == nil {
+		return o, fmt.Errorf("scheme of the API server is required")
+	}
+	if o.Client == nil && !o.InsecureSkipAuth {
+		return o, fmt.Errorf("client of the API server is required to authorize requests")
+	}
+	if o.Port == 0 {
+		o.Port = defaultPort
+	}
+	if o.Title == "" {
+		o.Title = "baaah"
+	}
+	if o.Version == "" {
+		o.Version = "unversioned"
+	}
+	return o, nil
+}
+
+// ResourceOptions are the options of a resource added to the server.
+type ResourceOptions struct {
+	// Resource is the plural name of the resource. Defaults to the lowercase plural of the kind.
+	Resource string
+	// ClusterScoped resources are not in a namespace.
+	ClusterScoped bool
+	ShortNames    []string
+	Categories    []string
+}
+
+type resource struct {
+	gvk     schema.GroupVersionKind
+	listGVK schema.GroupVersionKind
+	name    string
+	opts    ResourceOptions
+	storage Storage
+}
+
+func (r *resource) groupResource() schema.GroupResource {
+	return schema.GroupResource{Group: r.gvk.Group, Resource: r.name}
+}
+
+// Server is a lightweight aggregated API server. It serves discovery, the OpenAPI document, and the REST API of the
+// resources added to it, including watches. Requests are authenticated as the user set in the headers by the front
+// proxy of the Kubernetes API server and authorized by a SubjectAccessReview, so the service account of the server
+// needs the roles extension-apiserver-authentication-reader in kube-system and system:auth-delegator.
+type Server struct {
+	opts          Options
+	requestHeader *requestHeader
+
+	lock      sync.RWMutex
+	resources map[schema.GroupVersionResource]*resource
+	openAPI   []byte
+}
+
+func NewServer(opts Options) (*Server, error) {
+	opts, err := opts.complete()
+	if err != nil {
+		return nil, err
+	}
+	return &Server{
+		opts:      opts,
+		resources: map[schema.GroupVersionResource]*resource{},
+	}, nil
+}
+
+// AddResource serves the resource of the type returned by storage.New. The verbs of the resource are those
+// implemented by the storage.
+func (s *Server) AddResource(storage Storage, opts ResourceOptions) error {
+	gvk, err := apiutil.GVKForObject(storage.New(), s.opts.Scheme)
+	if err != nil {
+		return err
+	}
+	if gvk.Group == "" {
+		return fmt.Errorf("cannot serve %v, the core group is served by Kubernetes", gvk)
+	}
+
+	r := &resource{
+		gvk:     gvk,
+		listGVK: gvk.GroupVersion().WithKind(gvk.Kind + "List"),
+		name:    opts.Resource,
+		opts:    opts,
+		storage: storage,
+	}
+	if lister, ok := storage.(Lister); ok {
+		if r.listGVK, err = apiutil.GVKForObject(lister.NewList(), s.opts.Scheme); err != nil {
+			return err
+		}
+	}
+	if r.name == "" {
+		plural, _ := meta.UnsafeGuessKindToResource(gvk)
+		r.name = plural.Resource
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	gvr := gvk.GroupVersion().WithResource(r.name)
+	if _, ok := s.resources[gvr]; ok {
+		return fmt.Errorf("resource %v is already served", gvr)
+	}
+	s.resources[gvr] = r
+	// The OpenAPI document is built again with the new resource when it is next requested.
+	s.openAPI = nil
+	return nil
+}
+
+func (s *Server) resource(gvr schema.GroupVersionResource) (*resource, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	r, ok := s.resources[gvr]
+	return r, ok
+}
+
+// groupVersions returns the served versions of each group sorted by Kubernetes version priority.
+func (s *Server) groupVersions() map[string][]string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	result := map[string][]string{}
+	seen := map[schema.GroupVersion]bool{}
+	for gvr := range s.resources {
+		if gv := gvr.GroupVersion(); !seen[gv] {
+			seen[gv] = true
+			result[gv.Group] = append(result[gv.Group], gv.Version)
+		}
+	}
+	for _, versions := range result {
+		sort.Slice(versions, func(i, j int) bool {
+			return version.CompareKubeAwareVersionStrings(versions[i], versions[j]) > 0
+		})
+	}
+	return result
+}
+
+// resourcesFor returns the resources of the group version sorted by name.
+func (s *Server) resourcesFor(gv schema.GroupVersion) []*resource {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var result []*resource
+	for gvr, r := range s.resources {
+		if gvr.GroupVersion() == gv {
+			result = append(result, r)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].name < result[j].name
+	})
+	return result
+}
+
+func (s *Server) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	switch {
+	case req.URL.Path == "/healthz" || req.URL.Path == "/readyz" || req.URL.Path == "/livez":
+		_, _ = rw.Write([]byte("ok"))
+	case !s.opts.InsecureSkipAuth && !s.authorized(rw, req):
+		// The error has been written.
+	case req.URL.Path == "/openapi/v2":
+		s.serveOpenAPI(rw, req)
+	case parts[0] != "apis":
+		writeError(rw, notFound(req))
+	case len(parts) == 1:
+		s.serveGroupList(rw, req)
+	case len(parts) == 2:
+		s.serveGroup(rw, req, parts[1])
+	case len(parts) == 3:
+		s.serveResourceList(rw, req, schema.GroupVersion{Group: parts[1], Version: parts[2]})
+	default:
+		s.serveREST(rw, req, schema.GroupVersion{Group: parts[1], Version: parts[2]}, parts[3:])
+	}
+}
+
+// authorized returns whether the request is authenticated and authorized, or writes the error otherwise.
+func (s *Server) authorized(rw http.ResponseWriter, req *http.Request) bool {
+	user, err := s.requestHeader.authenticate(req)
+	if err == nil {
+		err = s.authorize(req, user)
+	}
+	if err != nil {
+		writeError(rw, err)
+		return false
+	}
+	return true
+}
+
+// Start serves the API until ctx is canceled. An error is returned if the port cannot be listened on or the front proxy
+// configuration cannot be read.
+func (s *Server) Start(ctx context.Context) error {
+	tlsConfig := s.opts.TLSConfig
+	if tlsConfig == nil {
+		certPEM, keyPEM, err := certutil.GenerateSelfSignedCertKey("localhost", nil, nil)
+		if err != nil {
+			return err
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return err
+		}
+		tlsConfig = &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{cert},
+		}
+	}
+	if !s.opts.InsecureSkipAuth {
+		requestHeader, err := loadRequestHeader(ctx, s.opts.Client)
+		if err != nil {
+			return err
+		}
+		s.requestHeader = requestHeader
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ClientCAs = requestHeader.clientCAs
+		// Health checks are served without a client certificate.
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	srv := &http.Server{
+		Addr:      fmt.Sprintf(":%d", s.opts.Port),
+		Handler:   s,
+		TLSConfig: tlsConfig,
+		// Watches are ended when ctx is canceled, so that the server can shut down.
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	// Listen before returning so that an error binding the port is returned to the caller.
+	listener, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for the API server: %w", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		if err := srv.Shutdown(context.WithoutCancel(ctx)); err != nil {
+			log.Warnf("error shutting down API server: %v", err)
+		}
+	}()
+	go func() {
+		log.Infof("API server stopped: %v", srv.ServeTLS(listener, "", ""))
+	}()
+	return nil
+}
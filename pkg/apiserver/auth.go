@@ -0,0 +1,196 @@
+package apiserver
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// authenticationConfigMap is where the Kubernetes API server publishes the CA and headers of its front proxy.
+var authenticationConfigMap = kclient.ObjectKey{Namespace: "kube-system", Name: "extension-apiserver-authentication"}
+
+// requestHeader authenticates requests proxied by the Kubernetes API server by the user it sets in the headers. The
+// headers are trusted only if the request has a client certificate signed by the front proxy CA.
+type requestHeader struct {
+	clientCAs           *x509.CertPool
+	allowedNames        []string
+	usernameHeaders     []string
+	groupHeaders        []string
+	extraHeaderPrefixes []string
+}
+
+type userInfo struct {
+	name   string
+	groups []string
+	extra  map[string]authorizationv1.ExtraValue
+}
+
+// loadRequestHeader reads the front proxy configuration of the Kubernetes API server.
+func loadRequestHeader(ctx context.Context, client kclient.Client) (*requestHeader, error) {
+	cm := &corev1.ConfigMap{}
+	if err := client.Get(ctx, authenticationConfigMap, cm); err != nil {
+		return nil, fmt.Errorf("failed to get the front proxy configuration in configmap %s: %w", authenticationConfigMap, err)
+	}
+
+	caPEM := cm.Data["requestheader-client-ca-file"]
+	if caPEM == "" {
+		return nil, fmt.Errorf("configmap %s has no front proxy CA, the Kubernetes API server is not configured for aggregation", authenticationConfigMap)
+	}
+	r := &requestHeader{
+		clientCAs: x509.NewCertPool(),
+	}
+	if !r.clientCAs.AppendCertsFromPEM([]byte(caPEM)) {
+		return nil, fmt.Errorf("invalid front proxy CA in configmap %s", authenticationConfigMap)
+	}
+
+	for key, value := range map[string]*[]string{
+		"requestheader-allowed-names":        &r.allowedNames,
+		"requestheader-username-headers":     &r.usernameHeaders,
+		"requestheader-group-headers":        &r.groupHeaders,
+		"requestheader-extra-headers-prefix": &r.extraHeaderPrefixes,
+	} {
+		if data := cm.Data[key]; data != "" {
+			if err := json.Unmarshal([]byte(data), value); err != nil {
+				return nil, fmt.Errorf("invalid %s in configmap %s: %w", key, authenticationConfigMap, err)
+			}
+		}
+	}
+	if len(r.usernameHeaders) == 0 {
+		r.usernameHeaders = []string{"X-Remote-User"}
+	}
+	if len(r.groupHeaders) == 0 {
+		r.groupHeaders = []string{"X-Remote-Group"}
+	}
+	if len(r.extraHeaderPrefixes) == 0 {
+		r.extraHeaderPrefixes = []string{"X-Remote-Extra-"}
+	}
+	return r, nil
+}
+
+func (r *requestHeader) authenticate(req *http.Request) (*userInfo, error) {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+		return nil, apierrors.NewUnauthorized("a client certificate of the front proxy is required")
+	}
+	if cn := req.TLS.VerifiedChains[0][0].Subject.CommonName; len(r.allowedNames) > 0 && !slices.Contains(r.allowedNames, cn) {
+		return nil, apierrors.NewUnauthorized(fmt.Sprintf("client certificate %q is not allowed to act as the front proxy", cn))
+	}
+
+	user := &userInfo{
+		extra: map[string]authorizationv1.ExtraValue{},
+	}
+	for _, header := range r.usernameHeaders {
+		if user.name = req.Header.Get(header); user.name != "" {
+			break
+		}
+	}
+	if user.name == "" {
+		return nil, apierrors.NewUnauthorized("no user was set by the front proxy")
+	}
+	for _, header := range r.groupHeaders {
+		user.groups = append(user.groups, req.Header.Values(header)...)
+	}
+	for header, values := range req.Header {
+		for _, prefix := range r.extraHeaderPrefixes {
+			if len(header) > len(prefix) && strings.EqualFold(header[:len(prefix)], prefix) {
+				// The keys are escaped by the front proxy, as header names cannot contain every character.
+				key, err := url.PathUnescape(header[len(prefix):])
+				if err != nil {
+					key = header[len(prefix):]
+				}
+				key = strings.ToLower(key)
+				user.extra[key] = append(user.extra[key], values...)
+			}
+		}
+	}
+	return user, nil
+}
+
+// authorize creates a SubjectAccessReview of the request of the user.
+func (s *Server) authorize(req *http.Request, user *userInfo) error {
+	sar := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.name,
+			Groups: user.groups,
+			Extra:  user.extra,
+		},
+	}
+	resourceAttributes, gr, name := resourceAttributes(req)
+	if resourceAttributes != nil {
+		sar.Spec.ResourceAttributes = resourceAttributes
+	} else {
+		sar.Spec.NonResourceAttributes = &authorizationv1.NonResourceAttributes{
+			Path: req.URL.Path,
+			Verb: strings.ToLower(req.Method),
+		}
+	}
+
+	if err := s.opts.Client.Create(req.Context(), sar); err != nil {
+		return fmt.Errorf("failed to authorize %s: %w", user.name, err)
+	}
+	if !sar.Status.Allowed {
+		reason := sar.Status.Reason
+		if reason == "" {
+			reason = "access denied"
+		}
+		return apierrors.NewForbidden(gr, name, fmt.Errorf("user %q cannot %s: %s", user.name, verbOf(req, name), reason))
+	}
+	return nil
+}
+
+// resourceAttributes returns the attributes of a request for the objects of a resource, or nil for any other request.
+func resourceAttributes(req *http.Request) (*authorizationv1.ResourceAttributes, schema.GroupResource, string) {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(parts) < 4 || parts[0] != "apis" {
+		return nil, schema.GroupResource{}, ""
+	}
+	attrs := &authorizationv1.ResourceAttributes{
+		Group:   parts[1],
+		Version: parts[2],
+	}
+	parts = parts[3:]
+	if len(parts) > 2 && parts[0] == "namespaces" {
+		attrs.Namespace, parts = parts[1], parts[2:]
+	}
+	attrs.Resource = parts[0]
+	if len(parts) > 1 {
+		attrs.Name = parts[1]
+	}
+	if len(parts) > 2 {
+		attrs.Subresource = parts[2]
+	}
+	attrs.Verb = verbOf(req, attrs.Name)
+	return attrs, schema.GroupResource{Group: attrs.Group, Resource: attrs.Resource}, attrs.Name
+}
+
+// verbOf returns the Kubernetes verb of a request for the objects of a resource.
+func verbOf(req *http.Request, name string) string {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		if name != "" {
+			return "get"
+		} else if watch := req.URL.Query().Get("watch"); watch == "true" || watch == "1" {
+			return "watch"
+		}
+		return "list"
+	case http.MethodPost:
+		return "create"
+	case http.MethodPut:
+		return "update"
+	case http.MethodPatch:
+		return "patch"
+	case http.MethodDelete:
+		return "delete"
+	}
+	return strings.ToLower(req.Method)
+}
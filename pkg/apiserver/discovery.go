@@ -0,0 +1,100 @@
+package apiserver
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func (s *Server) serveGroupList(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(rw, methodNotSupported(req))
+		return
+	}
+
+	groupVersions := s.groupVersions()
+	list := &metav1.APIGroupList{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "APIGroupList",
+			APIVersion: "v1",
+		},
+		Groups: []metav1.APIGroup{},
+	}
+	for group, versions := range groupVersions {
+		list.Groups = append(list.Groups, apiGroup(group, versions))
+	}
+	sort.Slice(list.Groups, func(i, j int) bool {
+		return list.Groups[i].Name < list.Groups[j].Name
+	})
+	writeJSON(rw, http.StatusOK, list)
+}
+
+func (s *Server) serveGroup(rw http.ResponseWriter, req *http.Request, group string) {
+	if req.Method != http.MethodGet {
+		writeError(rw, methodNotSupported(req))
+		return
+	}
+
+	versions, ok := s.groupVersions()[group]
+	if !ok {
+		writeError(rw, notFound(req))
+		return
+	}
+	result := apiGroup(group, versions)
+	result.TypeMeta = metav1.TypeMeta{
+		Kind:       "APIGroup",
+		APIVersion: "v1",
+	}
+	writeJSON(rw, http.StatusOK, &result)
+}
+
+// apiGroup returns the discovery of a group. The versions must be sorted by priority.
+func apiGroup(group string, versions []string) metav1.APIGroup {
+	result := metav1.APIGroup{
+		Name: group,
+	}
+	for _, version := range versions {
+		result.Versions = append(result.Versions, metav1.GroupVersionForDiscovery{
+			GroupVersion: schema.GroupVersion{Group: group, Version: version}.String(),
+			Version:      version,
+		})
+	}
+	result.PreferredVersion = result.Versions[0]
+	return result
+}
+
+func (s *Server) serveResourceList(rw http.ResponseWriter, req *http.Request, gv schema.GroupVersion) {
+	if req.Method != http.MethodGet {
+		writeError(rw, methodNotSupported(req))
+		return
+	}
+
+	resources := s.resourcesFor(gv)
+	if len(resources) == 0 {
+		writeError(rw, notFound(req))
+		return
+	}
+
+	list := &metav1.APIResourceList{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "APIResourceList",
+			APIVersion: "v1",
+		},
+		GroupVersion: gv.String(),
+	}
+	for _, r := range resources {
+		list.APIResources = append(list.APIResources, metav1.APIResource{
+			Name:         r.name,
+			SingularName: strings.ToLower(r.gvk.Kind),
+			Namespaced:   !r.opts.ClusterScoped,
+			Kind:         r.gvk.Kind,
+			Verbs:        verbs(r.storage),
+			ShortNames:   r.opts.ShortNames,
+			Categories:   r.opts.Categories,
+		})
+	}
+	writeJSON(rw, http.StatusOK, list)
+}
@@ -0,0 +1,235 @@
+package apiserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/acorn-io/baaah/pkg/log"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/utils/ptr"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// Translator converts between the objects of a resource and the objects of the Kubernetes resource they are stored
+// as. An object is stored with the same namespace and name.
+type Translator interface {
+	// FromPublic returns the object to store for an object of the resource.
+	FromPublic(ctx context.Context, obj kclient.Object) (kclient.Object, error)
+	// ToPublic returns the object of the resource for a stored object.
+	ToPublic(ctx context.Context, obj kclient.Object) (kclient.Object, error)
+}
+
+// KubernetesStorage stores the objects of a resource as the objects of another Kubernetes resource. It supports all
+// verbs. Label and field selectors are matched against the translated objects.
+type KubernetesStorage struct {
+	client     kclient.WithWatch
+	obj        kclient.Object
+	list       kclient.ObjectList
+	stored     kclient.Object
+	storedList kclient.ObjectList
+	translator Translator
+}
+
+// NewKubernetesStorage returns a storage for objects of the type of obj that are stored as objects of the type of
+// stored. The list types of both must be in the scheme of the client. If translator is nil, objects are converted by
+// copying all fields of the same JSON name, which suits types that are a view of the stored type.
+func NewKubernetesStorage(client kclient.WithWatch, obj, stored kclient.Object, translator Translator) (*KubernetesStorage, error) {
+	list, err := newList(client.Scheme(), obj)
+	if err != nil {
+		return nil, err
+	}
+	storedList, err := newList(client.Scheme(), stored)
+	if err != nil {
+		return nil, err
+	}
+	if translator == nil {
+		translator = jsonTranslator{
+			obj:    obj,
+			stored: stored,
+		}
+	}
+	return &KubernetesStorage{
+		client:     client,
+		obj:        obj,
+		list:       list,
+		stored:     stored,
+		storedList: storedList,
+		translator: translator,
+	}, nil
+}
+
+func newList(scheme *runtime.Scheme, obj kclient.Object) (kclient.ObjectList, error) {
+	gvk, err := apiutil.GVKForObject(obj, scheme)
+	if err != nil {
+		return nil, err
+	}
+	gvk.Kind += "List"
+	list, err := scheme.New(gvk)
+	if err != nil {
+		return nil, err
+	}
+	objList, ok := list.(kclient.ObjectList)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a list", list)
+	}
+	return objList, nil
+}
+
+func (k *KubernetesStorage) New() kclient.Object {
+	return k.obj.DeepCopyObject().(kclient.Object)
+}
+
+func (k *KubernetesStorage) NewList() kclient.ObjectList {
+	return k.list.DeepCopyObject().(kclient.ObjectList)
+}
+
+func (k *KubernetesStorage) newStored() kclient.Object {
+	return k.stored.DeepCopyObject().(kclient.Object)
+}
+
+func (k *KubernetesStorage) Get(ctx context.Context, namespace, name string) (kclient.Object, error) {
+	stored := k.newStored()
+	if err := k.client.Get(ctx, kclient.ObjectKey{Namespace: namespace, Name: name}, stored); err != nil {
+		return nil, err
+	}
+	return k.translator.ToPublic(ctx, stored)
+}
+
+func (k *KubernetesStorage) List(ctx context.Context, namespace string, opts *metav1.ListOptions) (kclient.ObjectList, error) {
+	matches, err := Predicate(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	storedList := k.storedList.DeepCopyObject().(kclient.ObjectList)
+	if err := k.client.List(ctx, storedList, kclient.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+
+	var items []runtime.Object
+	err = meta.EachListItem(storedList, func(item runtime.Object) error {
+		obj, err := k.translator.ToPublic(ctx, item.(kclient.Object))
+		if err != nil {
+			return err
+		}
+		if matches(obj) {
+			items = append(items, obj)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	list := k.NewList()
+	if err := meta.SetList(list, items); err != nil {
+		return nil, err
+	}
+	list.SetResourceVersion(storedList.GetResourceVersion())
+	return list, nil
+}
+
+// Watch returns a watch of the stored objects. Objects that fail to translate are logged and skipped.
+func (k *KubernetesStorage) Watch(ctx context.Context, namespace string, opts *metav1.ListOptions) (watch.Interface, error) {
+	matches, err := Predicate(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	listOpts := &kclient.ListOptions{
+		Namespace: namespace,
+	}
+	if opts != nil {
+		listOpts.Raw = &metav1.ListOptions{
+			ResourceVersion:     opts.ResourceVersion,
+			TimeoutSeconds:      opts.TimeoutSeconds,
+			AllowWatchBookmarks: opts.AllowWatchBookmarks,
+		}
+	}
+
+	w, err := k.client.Watch(ctx, k.storedList.DeepCopyObject().(kclient.ObjectList), listOpts)
+	if err != nil {
+		return nil, err
+	}
+	return watch.Filter(w, func(event watch.Event) (watch.Event, bool) {
+		stored, ok := event.Object.(kclient.Object)
+		if !ok || event.Type == watch.Error {
+			return event, true
+		}
+		obj, err := k.translator.ToPublic(ctx, stored)
+		if err != nil {
+			log.Errorf("failed to translate %s/%s in watch: %v", stored.GetNamespace(), stored.GetName(), err)
+			return event, false
+		}
+		if event.Type == watch.Bookmark {
+			return watch.Event{Type: event.Type, Object: obj}, true
+		}
+		return watch.Event{Type: event.Type, Object: obj}, matches(obj)
+	}), nil
+}
+
+func (k *KubernetesStorage) Create(ctx context.Context, obj kclient.Object) (kclient.Object, error) {
+	stored, err := k.translator.FromPublic(ctx, obj)
+	if err != nil {
+		return nil, err
+	}
+	if err := k.client.Create(ctx, stored); err != nil {
+		return nil, err
+	}
+	return k.translator.ToPublic(ctx, stored)
+}
+
+func (k *KubernetesStorage) Update(ctx context.Context, obj kclient.Object) (kclient.Object, error) {
+	stored, err := k.translator.FromPublic(ctx, obj)
+	if err != nil {
+		return nil, err
+	}
+	if err := k.client.Update(ctx, stored); err != nil {
+		return nil, err
+	}
+	return k.translator.ToPublic(ctx, stored)
+}
+
+func (k *KubernetesStorage) Delete(ctx context.Context, namespace, name string) (kclient.Object, error) {
+	stored := k.newStored()
+	if err := k.client.Get(ctx, kclient.ObjectKey{Namespace: namespace, Name: name}, stored); err != nil {
+		return nil, err
+	}
+	if err := k.client.Delete(ctx, stored, kclient.Preconditions{UID: ptr.To(stored.GetUID())}); err != nil {
+		return nil, err
+	}
+	return k.translator.ToPublic(ctx, stored)
+}
+
+// jsonTranslator converts objects by marshalling one type and unmarshalling into the other.
+type jsonTranslator struct {
+	obj    kclient.Object
+	stored kclient.Object
+}
+
+func (j jsonTranslator) FromPublic(_ context.Context, obj kclient.Object) (kclient.Object, error) {
+	return convertJSON(obj, j.stored)
+}
+
+func (j jsonTranslator) ToPublic(_ context.Context, obj kclient.Object) (kclient.Object, error) {
+	return convertJSON(obj, j.obj)
+}
+
+func convertJSON(from, to kclient.Object) (kclient.Object, error) {
+	data, err := json.Marshal(from)
+	if err != nil {
+		return nil, err
+	}
+	result := to.DeepCopyObject().(kclient.Object)
+	if err := json.Unmarshal(data, result); err != nil {
+		return nil, err
+	}
+	// The type of the result is set by the client or the server.
+	result.GetObjectKind().SetGroupVersionKind(to.GetObjectKind().GroupVersionKind())
+	return result, nil
+}
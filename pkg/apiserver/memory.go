@@ -0,0 +1,243 @@
+package apiserver
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/acorn-io/baaah/pkg/typed"
+	"github.com/google/uuid"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/watch"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const watchQueueLength = 1000
+
+var errResourceVersion = errors.New("the object has been modified; please apply your changes to the latest version and try again")
+
+// MemoryStorage stores objects of type T in memory. It supports all verbs. Objects are lost when the process exits and
+// are not shared between replicas.
+type MemoryStorage[T kclient.Object, L kclient.ObjectList] struct {
+	gr schema.GroupResource
+
+	lock            sync.Mutex
+	objects         map[types.NamespacedName]T
+	resourceVersion uint64
+	watchers        map[*memoryWatcher]struct{}
+}
+
+// NewMemoryStorage returns a storage for objects of type T with lists of type L. The group resource is used in the
+// not found and conflict errors.
+func NewMemoryStorage[T kclient.Object, L kclient.ObjectList](gr schema.GroupResource) *MemoryStorage[T, L] {
+	return &MemoryStorage[T, L]{
+		gr:       gr,
+		objects:  map[types.NamespacedName]T{},
+		watchers: map[*memoryWatcher]struct{}{},
+	}
+}
+
+func (m *MemoryStorage[T, L]) New() kclient.Object {
+	return typed.New[T]()
+}
+
+func (m *MemoryStorage[T, L]) NewList() kclient.ObjectList {
+	return typed.New[L]()
+}
+
+func (m *MemoryStorage[T, L]) Get(_ context.Context, namespace, name string) (kclient.Object, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	obj, ok := m.objects[types.NamespacedName{Namespace: namespace, Name: name}]
+	if !ok {
+		return nil, apierrors.NewNotFound(m.gr, name)
+	}
+	return obj.DeepCopyObject().(kclient.Object), nil
+}
+
+func (m *MemoryStorage[T, L]) List(_ context.Context, namespace string, opts *metav1.ListOptions) (kclient.ObjectList, error) {
+	matches, err := Predicate(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	list := m.NewList()
+	if err := meta.SetList(list, m.items(namespace, matches)); err != nil {
+		return nil, err
+	}
+	list.SetResourceVersion(strconv.FormatUint(m.resourceVersion, 10))
+	return list, nil
+}
+
+// items returns copies of the objects in the namespace that match. All objects are returned if namespace is empty.
+func (m *MemoryStorage[T, L]) items(namespace string, matches func(kclient.Object) bool) []runtime.Object {
+	var result []runtime.Object
+	for key, obj := range m.objects {
+		if namespace != "" && key.Namespace != namespace || !matches(obj) {
+			continue
+		}
+		result = append(result, obj.DeepCopyObject())
+	}
+	return result
+}
+
+// Watch returns a watch of the changes after the request. If no resource version or "0" is requested, the watch
+// first sends an added event for every existing object. Older changes are not kept, so any other resource version is
+// watched from the current state. A watch that falls more than watchQueueLength events behind ends with an expired
+// error, so that the client lists again instead of slowing down the storage.
+func (m *MemoryStorage[T, L]) Watch(_ context.Context, namespace string, opts *metav1.ListOptions) (watch.Interface, error) {
+	matches, err := Predicate(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var initial []runtime.Object
+	if opts == nil || opts.ResourceVersion == "" || opts.ResourceVersion == "0" {
+		initial = m.items(namespace, matches)
+	}
+
+	w := &memoryWatcher{
+		matches: func(obj kclient.Object) bool {
+			return (namespace == "" || obj.GetNamespace() == namespace) && matches(obj)
+		},
+		// One more event than the queue length is kept for the error that ends the watch.
+		result: make(chan watch.Event, len(initial)+watchQueueLength+1),
+		stop:   m.stopWatch,
+	}
+	for _, obj := range initial {
+		w.result <- watch.Event{Type: watch.Added, Object: obj}
+	}
+	m.watchers[w] = struct{}{}
+	return w, nil
+}
+
+func (m *MemoryStorage[T, L]) stopWatch(w *memoryWatcher) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.watchers[w]; ok {
+		delete(m.watchers, w)
+		close(w.result)
+	}
+}
+
+// send queues the event to the watches of the object without blocking. A watch whose queue is full is ended. The lock
+// must be held.
+func (m *MemoryStorage[T, L]) send(eventType watch.EventType, obj kclient.Object) {
+	for w := range m.watchers {
+		if !w.matches(obj) {
+			continue
+		}
+		if len(w.result) < cap(w.result)-1 {
+			w.result <- watch.Event{Type: eventType, Object: obj.DeepCopyObject()}
+			continue
+		}
+		status := apierrors.NewResourceExpired("the watch fell too far behind; list again to resume").ErrStatus
+		w.result <- watch.Event{Type: watch.Error, Object: &status}
+		delete(m.watchers, w)
+		close(w.result)
+	}
+}
+
+type memoryWatcher struct {
+	matches func(kclient.Object) bool
+	result  chan watch.Event
+	stop    func(*memoryWatcher)
+}
+
+func (w *memoryWatcher) Stop() {
+	w.stop(w)
+}
+
+func (w *memoryWatcher) ResultChan() <-chan watch.Event {
+	return w.result
+}
+
+func (m *MemoryStorage[T, L]) Create(_ context.Context, obj kclient.Object) (kclient.Object, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if obj.GetName() == "" && obj.GetGenerateName() != "" {
+		obj.SetName(obj.GetGenerateName() + rand.String(5))
+	}
+	if obj.GetName() == "" {
+		return nil, apierrors.NewBadRequest("name or generateName is required")
+	}
+
+	key := kclient.ObjectKeyFromObject(obj)
+	if _, ok := m.objects[key]; ok {
+		return nil, apierrors.NewAlreadyExists(m.gr, obj.GetName())
+	}
+
+	obj.SetUID(types.UID(uuid.NewString()))
+	obj.SetCreationTimestamp(metav1.Now())
+	obj.SetDeletionTimestamp(nil)
+	obj.SetGeneration(1)
+	return m.store(key, obj, watch.Added)
+}
+
+func (m *MemoryStorage[T, L]) Update(_ context.Context, obj kclient.Object) (kclient.Object, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	key := kclient.ObjectKeyFromObject(obj)
+	existing, ok := m.objects[key]
+	if !ok {
+		return nil, apierrors.NewNotFound(m.gr, obj.GetName())
+	}
+	if rv := obj.GetResourceVersion(); rv != "" && rv != existing.GetResourceVersion() {
+		return nil, apierrors.NewConflict(m.gr, obj.GetName(), errResourceVersion)
+	}
+
+	obj.SetUID(existing.GetUID())
+	obj.SetCreationTimestamp(existing.GetCreationTimestamp())
+	obj.SetDeletionTimestamp(existing.GetDeletionTimestamp())
+	obj.SetGeneration(existing.GetGeneration() + 1)
+	return m.store(key, obj, watch.Modified)
+}
+
+func (m *MemoryStorage[T, L]) Delete(_ context.Context, namespace, name string) (kclient.Object, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	key := types.NamespacedName{Namespace: namespace, Name: name}
+	existing, ok := m.objects[key]
+	if !ok {
+		return nil, apierrors.NewNotFound(m.gr, name)
+	}
+	delete(m.objects, key)
+
+	m.resourceVersion++
+	existing.SetResourceVersion(strconv.FormatUint(m.resourceVersion, 10))
+	m.send(watch.Deleted, existing)
+	return existing, nil
+}
+
+// store saves a copy of the object with a new resource version and queues the event to the watches. The lock must be
+// held.
+func (m *MemoryStorage[T, L]) store(key types.NamespacedName, obj kclient.Object, eventType watch.EventType) (kclient.Object, error) {
+	stored, ok := obj.DeepCopyObject().(T)
+	if !ok {
+		return nil, apierrors.NewBadRequest("object is not of the type of the storage")
+	}
+
+	m.resourceVersion++
+	stored.SetResourceVersion(strconv.FormatUint(m.resourceVersion, 10))
+	m.objects[key] = stored
+
+	m.send(eventType, stored)
+	return stored.DeepCopyObject().(kclient.Object), nil
+}
@@ -0,0 +1,110 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/kube-openapi/pkg/builder"
+	"k8s.io/kube-openapi/pkg/common"
+	"k8s.io/kube-openapi/pkg/util"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+)
+
+const gvkExtension = "x-kubernetes-group-version-kind"
+
+func (s *Server) serveOpenAPI(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(rw, methodNotSupported(req))
+		return
+	}
+	if s.opts.OpenAPIDefinitions == nil {
+		writeError(rw, notFound(req))
+		return
+	}
+
+	data, err := s.openAPIDocument()
+	if err != nil {
+		writeError(rw, err)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	_, _ = rw.Write(data)
+}
+
+// openAPIDocument returns the OpenAPI v2 document with the definitions of the types of all resources. It is built on
+// first use and cached until a resource is added.
+func (s *Server) openAPIDocument() ([]byte, error) {
+	s.lock.RLock()
+	data := s.openAPI
+	s.lock.RUnlock()
+	if data != nil {
+		return data, nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.openAPI != nil {
+		return s.openAPI, nil
+	}
+
+	var names []string
+	for _, r := range s.resources {
+		names = append(names, util.GetCanonicalTypeName(r.storage.New()))
+		if lister, ok := r.storage.(Lister); ok {
+			names = append(names, util.GetCanonicalTypeName(lister.NewList()))
+		}
+	}
+	sort.Strings(names)
+
+	swagger, err := builder.BuildOpenAPIDefinitionsForResources(&common.Config{
+		Info: &spec.Info{
+			InfoProps: spec.InfoProps{
+				Title:   s.opts.Title,
+				Version: s.opts.Version,
+			},
+		},
+		GetDefinitions:    s.opts.OpenAPIDefinitions,
+		GetDefinitionName: s.definitionNamer(),
+	}, names...)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.openAPI, err = json.Marshal(swagger); err != nil {
+		return nil, err
+	}
+	return s.openAPI, nil
+}
+
+// definitionNamer returns a func that names definitions as the Kubernetes API server does, with the Go package path
+// reversed, such as io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta. The definitions of the types in the scheme have
+// the extension listing their group, version, and kind.
+func (s *Server) definitionNamer() func(name string) (string, spec.Extensions) {
+	gvks := map[string][]schema.GroupVersionKind{}
+	for gvk, t := range s.opts.Scheme.AllKnownTypes() {
+		name := t.PkgPath() + "." + t.Name()
+		gvks[name] = append(gvks[name], gvk)
+	}
+
+	extensions := map[string]spec.Extensions{}
+	for name, list := range gvks {
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].String() < list[j].String()
+		})
+		var values []any
+		for _, gvk := range list {
+			values = append(values, map[string]any{
+				"group":   gvk.Group,
+				"version": gvk.Version,
+				"kind":    gvk.Kind,
+			})
+		}
+		extensions[name] = spec.Extensions{gvkExtension: values}
+	}
+
+	return func(name string) (string, spec.Extensions) {
+		return util.ToRESTFriendlyName(name), extensions[name]
+	}
+}
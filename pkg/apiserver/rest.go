@@ -0,0 +1,353 @@
+package apiserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/acorn-io/baaah/pkg/log"
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// maxBodySize is the limit of the size of request bodies, the same as that of the Kubernetes API server.
+const maxBodySize = 3 << 20
+
+// serveREST serves the requests for the objects of a resource. The path parts are those after the group version.
+func (s *Server) serveREST(rw http.ResponseWriter, req *http.Request, gv schema.GroupVersion, parts []string) {
+	var namespace, name string
+	if len(parts) > 2 && parts[0] == "namespaces" {
+		namespace, parts = parts[1], parts[2:]
+	}
+	if len(parts) == 2 {
+		name = parts[1]
+	} else if len(parts) > 2 {
+		// Subresources are not supported.
+		writeError(rw, notFound(req))
+		return
+	}
+
+	r, ok := s.resource(gv.WithResource(parts[0]))
+	if !ok || r.opts.ClusterScoped && namespace != "" || !r.opts.ClusterScoped && namespace == "" && name != "" {
+		writeError(rw, notFound(req))
+		return
+	}
+
+	query := req.URL.Query()
+	if len(query["dryRun"]) > 0 {
+		writeError(rw, apierrors.NewBadRequest("dry run is not supported"))
+		return
+	}
+
+	var err error
+	switch {
+	case name == "" && req.Method == http.MethodGet:
+		opts := &metav1.ListOptions{}
+		if err := metav1.Convert_url_Values_To_v1_ListOptions(&query, opts, nil); err != nil {
+			writeError(rw, apierrors.NewBadRequest(err.Error()))
+			return
+		}
+		if opts.Watch {
+			err = s.watch(rw, req, r, namespace, opts)
+		} else {
+			err = s.list(rw, req, r, namespace, opts)
+		}
+	case name == "" && req.Method == http.MethodPost:
+		err = s.create(rw, req, r, namespace)
+	case name != "" && req.Method == http.MethodGet:
+		err = s.get(rw, req, r, namespace, name)
+	case name != "" && req.Method == http.MethodPut:
+		err = s.update(rw, req, r, namespace, name)
+	case name != "" && req.Method == http.MethodPatch:
+		err = s.patch(rw, req, r, namespace, name)
+	case name != "" && req.Method == http.MethodDelete:
+		err = s.delete(rw, req, r, namespace, name)
+	default:
+		err = methodNotSupported(req)
+	}
+	if err != nil {
+		writeError(rw, err)
+	}
+}
+
+func (s *Server) get(rw http.ResponseWriter, req *http.Request, r *resource, namespace, name string) error {
+	getter, ok := r.storage.(Getter)
+	if !ok {
+		return apierrors.NewMethodNotSupported(r.groupResource(), "get")
+	}
+	obj, err := getter.Get(req.Context(), namespace, name)
+	if err != nil {
+		return err
+	}
+	writeObject(rw, http.StatusOK, r.gvk, obj)
+	return nil
+}
+
+func (s *Server) list(rw http.ResponseWriter, req *http.Request, r *resource, namespace string, opts *metav1.ListOptions) error {
+	lister, ok := r.storage.(Lister)
+	if !ok {
+		return apierrors.NewMethodNotSupported(r.groupResource(), "list")
+	}
+	list, err := lister.List(req.Context(), namespace, opts)
+	if err != nil {
+		return err
+	}
+	writeObject(rw, http.StatusOK, r.listGVK, list)
+	return nil
+}
+
+func (s *Server) create(rw http.ResponseWriter, req *http.Request, r *resource, namespace string) error {
+	creator, ok := r.storage.(Creator)
+	if !ok {
+		return apierrors.NewMethodNotSupported(r.groupResource(), "create")
+	}
+	if !r.opts.ClusterScoped && namespace == "" {
+		return apierrors.NewBadRequest("the namespace of the request is required")
+	}
+	obj, err := s.decode(rw, req, r, namespace, "")
+	if err != nil {
+		return err
+	}
+	obj, err = creator.Create(req.Context(), obj)
+	if err != nil {
+		return err
+	}
+	writeObject(rw, http.StatusCreated, r.gvk, obj)
+	return nil
+}
+
+func (s *Server) update(rw http.ResponseWriter, req *http.Request, r *resource, namespace, name string) error {
+	updater, ok := r.storage.(Updater)
+	if !ok {
+		return apierrors.NewMethodNotSupported(r.groupResource(), "update")
+	}
+	obj, err := s.decode(rw, req, r, namespace, name)
+	if err != nil {
+		return err
+	}
+	obj, err = updater.Update(req.Context(), obj)
+	if err != nil {
+		return err
+	}
+	writeObject(rw, http.StatusOK, r.gvk, obj)
+	return nil
+}
+
+// patch applies a JSON or merge patch to the current object and updates it. Strategic merge and apply patches are not
+// supported, as for custom resources.
+func (s *Server) patch(rw http.ResponseWriter, req *http.Request, r *resource, namespace, name string) error {
+	getter, isGetter := r.storage.(Getter)
+	updater, isUpdater := r.storage.(Updater)
+	if !isGetter || !isUpdater {
+		return apierrors.NewMethodNotSupported(r.groupResource(), "patch")
+	}
+
+	patch, err := io.ReadAll(http.MaxBytesReader(rw, req.Body, maxBodySize))
+	if err != nil {
+		return apierrors.NewBadRequest(err.Error())
+	}
+
+	existing, err := getter.Get(req.Context(), namespace, name)
+	if err != nil {
+		return err
+	}
+	original, err := marshal(r.gvk, existing)
+	if err != nil {
+		return err
+	}
+
+	var patched []byte
+	contentType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	switch types.PatchType(contentType) {
+	case types.JSONPatchType:
+		p, err := jsonpatch.DecodePatch(patch)
+		if err != nil {
+			return apierrors.NewBadRequest(err.Error())
+		}
+		if patched, err = p.Apply(original); err != nil {
+			return apierrors.NewBadRequest(err.Error())
+		}
+	case types.MergePatchType:
+		if patched, err = jsonpatch.MergePatch(original, patch); err != nil {
+			return apierrors.NewBadRequest(err.Error())
+		}
+	default:
+		return apierrors.NewGenericServerResponse(http.StatusUnsupportedMediaType, "patch", r.groupResource(), name,
+			fmt.Sprintf("the patch type %q is not supported", contentType), 0, false)
+	}
+
+	obj, err := r.unmarshal(patched, namespace, name)
+	if err != nil {
+		return err
+	}
+	obj, err = updater.Update(req.Context(), obj)
+	if err != nil {
+		return err
+	}
+	writeObject(rw, http.StatusOK, r.gvk, obj)
+	return nil
+}
+
+func (s *Server) delete(rw http.ResponseWriter, req *http.Request, r *resource, namespace, name string) error {
+	deleter, ok := r.storage.(Deleter)
+	if !ok {
+		return apierrors.NewMethodNotSupported(r.groupResource(), "delete")
+	}
+	obj, err := deleter.Delete(req.Context(), namespace, name)
+	if err != nil {
+		return err
+	}
+	writeObject(rw, http.StatusOK, r.gvk, obj)
+	return nil
+}
+
+// watch streams the events of the watch until the watch ends, the timeout of the request passes, or the client goes
+// away.
+func (s *Server) watch(rw http.ResponseWriter, req *http.Request, r *resource, namespace string, opts *metav1.ListOptions) error {
+	watcher, ok := r.storage.(Watcher)
+	if !ok {
+		return apierrors.NewMethodNotSupported(r.groupResource(), "watch")
+	}
+
+	ctx := req.Context()
+	if opts.TimeoutSeconds != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(*opts.TimeoutSeconds)*time.Second)
+		defer cancel()
+	}
+
+	w, err := watcher.Watch(ctx, namespace, opts)
+	if err != nil {
+		return err
+	}
+	defer w.Stop()
+
+	flusher, _ := rw.(http.Flusher)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	enc := json.NewEncoder(rw)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return nil
+			}
+			gvk := r.gvk
+			if event.Type == watch.Error {
+				gvk = schema.GroupVersionKind{Version: "v1", Kind: "Status"}
+			}
+			data, err := marshal(gvk, event.Object)
+			if err != nil {
+				log.Errorf("failed to encode watch event of %v: %v", r.gvk, err)
+				return nil
+			}
+			if err := enc.Encode(&metav1.WatchEvent{Type: string(event.Type), Object: runtime.RawExtension{Raw: data}}); err != nil {
+				return nil
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// decode returns the object in the body of the request. The namespace of the object defaults to that of the request.
+func (s *Server) decode(rw http.ResponseWriter, req *http.Request, r *resource, namespace, name string) (kclient.Object, error) {
+	data, err := io.ReadAll(http.MaxBytesReader(rw, req.Body, maxBodySize))
+	if err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+	return r.unmarshal(data, namespace, name)
+}
+
+// unmarshal returns the object in data and checks that it is of the resource and has the namespace and name of the
+// request. The name is not checked if empty.
+func (r *resource) unmarshal(data []byte, namespace, name string) (kclient.Object, error) {
+	obj := r.storage.New()
+	if err := json.Unmarshal(data, obj); err != nil {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("failed to decode %s: %v", r.gvk.Kind, err))
+	}
+	if gvk := obj.GetObjectKind().GroupVersionKind(); !gvk.Empty() && gvk != r.gvk {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("the object is a %v, not a %v", gvk, r.gvk))
+	}
+	if obj.GetNamespace() == "" {
+		obj.SetNamespace(namespace)
+	} else if obj.GetNamespace() != namespace {
+		return nil, apierrors.NewBadRequest("the namespace of the object does not match the namespace of the request")
+	}
+	if name != "" && obj.GetName() != name {
+		return nil, apierrors.NewBadRequest("the name of the object does not match the name of the request")
+	}
+	obj.GetObjectKind().SetGroupVersionKind(schema.GroupVersionKind{})
+	return obj, nil
+}
+
+// marshal returns the JSON of a copy of the object with the type set.
+func marshal(gvk schema.GroupVersionKind, obj runtime.Object) ([]byte, error) {
+	obj = obj.DeepCopyObject()
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+	return json.Marshal(obj)
+}
+
+func writeObject(rw http.ResponseWriter, code int, gvk schema.GroupVersionKind, obj runtime.Object) {
+	data, err := marshal(gvk, obj)
+	if err != nil {
+		writeError(rw, err)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	_, _ = rw.Write(data)
+}
+
+func writeJSON(rw http.ResponseWriter, code int, obj any) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	_ = json.NewEncoder(rw).Encode(obj)
+}
+
+// writeError writes the status of an error with an API status, or an internal error for any other error.
+func writeError(rw http.ResponseWriter, err error) {
+	var apiStatus apierrors.APIStatus
+	if !errors.As(err, &apiStatus) {
+		log.Errorf("%v", err)
+		apiStatus = apierrors.NewInternalError(err)
+	}
+	status := apiStatus.Status()
+	status.TypeMeta = metav1.TypeMeta{
+		Kind:       "Status",
+		APIVersion: "v1",
+	}
+	code := int(status.Code)
+	if code == 0 {
+		code = http.StatusInternalServerError
+	}
+	writeJSON(rw, code, &status)
+}
+
+func notFound(req *http.Request) error {
+	return apierrors.NewGenericServerResponse(http.StatusNotFound, req.Method, schema.GroupResource{}, "",
+		fmt.Sprintf("the path %s was not found", req.URL.Path), 0, false)
+}
+
+func methodNotSupported(req *http.Request) error {
+	return apierrors.NewGenericServerResponse(http.StatusMethodNotAllowed, req.Method, schema.GroupResource{}, "",
+		fmt.Sprintf("the method %s is not supported for %s", req.Method, req.URL.Path), 0, false)
+}
@@ -0,0 +1,107 @@
+package apiserver
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/watch"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Storage stores the objects of a resource. A storage also implements the interfaces below for the verbs it supports,
+// and only those verbs are served and advertised in discovery. Errors with an API status, such as those of
+// k8s.io/apimachinery/pkg/api/errors, are returned to the client as is.
+type Storage interface {
+	// New returns an empty object of the type of the resource.
+	New() kclient.Object
+}
+
+type Getter interface {
+	Get(ctx context.Context, namespace, name string) (kclient.Object, error)
+}
+
+type Lister interface {
+	// NewList returns an empty list of the type of the resource.
+	NewList() kclient.ObjectList
+	List(ctx context.Context, namespace string, opts *metav1.ListOptions) (kclient.ObjectList, error)
+}
+
+type Watcher interface {
+	Watch(ctx context.Context, namespace string, opts *metav1.ListOptions) (watch.Interface, error)
+}
+
+type Creator interface {
+	Create(ctx context.Context, obj kclient.Object) (kclient.Object, error)
+}
+
+type Updater interface {
+	Update(ctx context.Context, obj kclient.Object) (kclient.Object, error)
+}
+
+type Deleter interface {
+	Delete(ctx context.Context, namespace, name string) (kclient.Object, error)
+}
+
+// verbs returns the verbs supported by the storage. Patch is supported by storages that can get and update.
+func verbs(storage Storage) []string {
+	var result []string
+	if _, ok := storage.(Creator); ok {
+		result = append(result, "create")
+	}
+	if _, ok := storage.(Deleter); ok {
+		result = append(result, "delete")
+	}
+	if _, ok := storage.(Getter); ok {
+		result = append(result, "get")
+	}
+	if _, ok := storage.(Lister); ok {
+		result = append(result, "list")
+	}
+	_, getter := storage.(Getter)
+	if _, ok := storage.(Updater); ok {
+		if getter {
+			result = append(result, "patch")
+		}
+		result = append(result, "update")
+	}
+	if _, ok := storage.(Watcher); ok {
+		result = append(result, "watch")
+	}
+	return result
+}
+
+// Predicate returns a func that matches objects against the label selector and the metadata.name and
+// metadata.namespace field selectors of the options. It is for storages that filter lists and watches themselves.
+func Predicate(opts *metav1.ListOptions) (func(obj kclient.Object) bool, error) {
+	labelSelector, fieldSelector := labels.Everything(), fields.Everything()
+	if opts != nil && opts.LabelSelector != "" {
+		sel, err := labels.Parse(opts.LabelSelector)
+		if err != nil {
+			return nil, apierrors.NewBadRequest(err.Error())
+		}
+		labelSelector = sel
+	}
+	if opts != nil && opts.FieldSelector != "" {
+		sel, err := fields.ParseSelector(opts.FieldSelector)
+		if err != nil {
+			return nil, apierrors.NewBadRequest(err.Error())
+		}
+		for _, req := range sel.Requirements() {
+			if req.Field != "metadata.name" && req.Field != "metadata.namespace" {
+				return nil, apierrors.NewBadRequest(fmt.Sprintf("field label not supported: %s", req.Field))
+			}
+		}
+		fieldSelector = sel
+	}
+
+	return func(obj kclient.Object) bool {
+		return labelSelector.Matches(labels.Set(obj.GetLabels())) && fieldSelector.Matches(fields.Set{
+			"metadata.name":      obj.GetName(),
+			"metadata.namespace": obj.GetNamespace(),
+		})
+	}, nil
+}
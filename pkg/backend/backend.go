@@ -2,6 +2,7 @@ package backend
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
@@ -10,6 +11,11 @@ import (
 	kclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// ErrUnwatched is the cause of the cancellation of a watcher's context when the kind is no longer used. Only then may
+// the backend stop the informer of the kind once it has no watchers; watchers that end for other reasons, such as
+// losing leadership, keep the cache warm.
+var ErrUnwatched = errors.New("kind is no longer watched")
+
 type Callback func(gvk schema.GroupVersionKind, key string, obj runtime.Object) (runtime.Object, error)
 
 type Trigger interface {
@@ -20,6 +26,50 @@ type Watcher interface {
 	Watcher(ctx context.Context, gvk schema.GroupVersionKind, name string, cb Callback) error
 }
 
+// MetadataWatcher is optionally implemented by a Backend that can watch a kind caching only the metadata of the objects.
+// The callback is passed a *metav1.PartialObjectMetadata.
+type MetadataWatcher interface {
+	MetadataWatcher(ctx context.Context, gvk schema.GroupVersionKind, name string, cb Callback) error
+}
+
+// WorkerSetter is optionally implemented by a Backend that can change the number of workers for a kind at runtime.
+type WorkerSetter interface {
+	SetWorkers(gvk schema.GroupVersionKind, workers int) error
+}
+
+// Resyncer is optionally implemented by a Backend that can enqueue keys to be processed after changes and triggers.
+type Resyncer interface {
+	Resync(gvk schema.GroupVersionKind, key string) error
+}
+
+// CacheStarter is optionally implemented by a Backend whose cache can be started separately from its controllers. The
+// cache must then outlive the context passed to Start.
+type CacheStarter interface {
+	StartCache(ctx context.Context)
+}
+
+// WorkerCounter is optionally implemented by a Backend that can report the number of workers processing a kind.
+type WorkerCounter interface {
+	Workers(gvk schema.GroupVersionKind) int
+}
+
+// SyncChecker is optionally implemented by a Backend that can report whether the cache of a kind has synced.
+type SyncChecker interface {
+	HasSynced(ctx context.Context, gvk schema.GroupVersionKind) (bool, error)
+}
+
+// DeleteNotifier is optionally implemented by a Backend that can call back as soon as an object is deleted from the
+// cache, before the deletion is processed by the watchers of the kind. This is used to cancel running reconciles.
+type DeleteNotifier interface {
+	OnDelete(ctx context.Context, gvk schema.GroupVersionKind, cb func(key string)) error
+}
+
+// Drainer is optionally implemented by a Backend that can stop processing keys and wait for the keys being processed
+// to be done.
+type Drainer interface {
+	Drain(ctx context.Context) error
+}
+
 type Backend interface {
 	Trigger
 	CacheFactory
@@ -0,0 +1,146 @@
+package gen
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/acorn-io/baaah/pkg/router"
+	"sigs.k8s.io/controller-tools/pkg/crd"
+	"sigs.k8s.io/controller-tools/pkg/deepcopy"
+	"sigs.k8s.io/controller-tools/pkg/genall"
+	"sigs.k8s.io/controller-tools/pkg/rbac"
+	"sigs.k8s.io/controller-tools/pkg/webhook"
+)
+
+const (
+	CRD      = "crd"
+	RBAC     = "rbac"
+	Deepcopy = "deepcopy"
+	Webhook  = "webhook"
+
+	defaultOutputDir = "config"
+	defaultRoleName  = "manager-role"
+)
+
+// Generators are the names of all generators, in the order they run.
+var Generators = []string{Deepcopy, CRD, RBAC, Webhook}
+
+type Options struct {
+	// OutputDir is the directory manifests are written to, in a subdirectory named after the generator. Deepcopy
+	// funcs are written to the packages of the types. Defaults to "config".
+	OutputDir string
+	// RoleName is the name of the generated ClusterRole. Defaults to "manager-role".
+	RoleName string
+	// Router adds the rules it needs to the generated ClusterRole. See router.PolicyRules.
+	Router *router.Router
+}
+
+func (o Options) complete() Options {
+	if o.OutputDir == "" {
+		o.OutputDir = defaultOutputDir
+	}
+	if o.RoleName == "" {
+		o.RoleName = defaultRoleName
+	}
+	return o
+}
+
+// Run runs the named generators on the Go packages matching the patterns, such as ./pkg/apis/... CRDs are generated
+// with the kubebuilder markers of the types, the ClusterRole with the kubebuilder:rbac markers and the router, and the
+// webhook configurations with the kubebuilder:webhook markers.
+func Run(opts Options, generators []string, patterns ...string) error {
+	opts = opts.complete()
+
+	var (
+		gens  genall.Generators
+		rules = genall.OutputRules{
+			Default:     genall.OutputArtifacts{Config: genall.OutputToDirectory(opts.OutputDir)},
+			ByGenerator: map[*genall.Generator]genall.OutputRule{},
+		}
+	)
+	for _, name := range generators {
+		var g genall.Generator
+		switch name {
+		case CRD:
+			g = crd.Generator{}
+		case RBAC:
+			g = rbacGenerator{
+				Generator: rbac.Generator{RoleName: opts.RoleName},
+				router:    opts.Router,
+			}
+		case Deepcopy:
+			g = deepcopy.Generator{}
+		case Webhook:
+			g = webhook.Generator{}
+		default:
+			return fmt.Errorf("unknown generator %q, must be one of %s", name, strings.Join(Generators, ", "))
+		}
+		gens = append(gens, &g)
+		rules.ByGenerator[&g] = genall.OutputArtifacts{
+			Config: genall.OutputToDirectory(filepath.Join(opts.OutputDir, name)),
+		}
+	}
+
+	runtime, err := gens.ForRoots(patterns...)
+	if err != nil {
+		return err
+	}
+	runtime.OutputRules = rules
+	if runtime.Run() {
+		return fmt.Errorf("failed to generate %s for %s", strings.Join(generators, ", "), strings.Join(patterns, " "))
+	}
+	return nil
+}
+
+// Main runs the gen command with the arguments of the process and exits if it fails. The baaah command has no router,
+// so projects that want the rules of their router in the ClusterRole run this from their own command with the router
+// set in opts.
+//
+//	gen <crd|rbac|deepcopy|webhook|all>[,...] [-output-dir dir] [-role-name name] <packages...>
+func Main(opts Options) {
+	if err := main(opts, os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func main(opts Options, args []string) error {
+	if len(args) > 0 && args[0] == "gen" {
+		args = args[1:]
+	}
+	opts = opts.complete()
+
+	flags := flag.NewFlagSet("gen", flag.ContinueOnError)
+	flags.StringVar(&opts.OutputDir, "output-dir", opts.OutputDir, "directory to write manifests to")
+	flags.StringVar(&opts.RoleName, "role-name", opts.RoleName, "name of the generated ClusterRole")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: gen <%s|all>[,...] [flags] <packages...>\n", strings.Join(Generators, "|"))
+		flags.PrintDefaults()
+	}
+
+	if len(args) == 0 {
+		flags.Usage()
+		return fmt.Errorf("a generator is required")
+	}
+	generators := strings.Split(args[0], ",")
+	if args[0] == "all" {
+		generators = Generators
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("a package is required")
+	}
+
+	if opts.Router == nil && slices.Contains(generators, RBAC) {
+		fmt.Fprintln(os.Stderr, "warning: no router is set, so the ClusterRole only has the rules of the kubebuilder:rbac markers. "+
+			"Run gen.Main with Options.Router from the command of your project to include the rules of the router.")
+	}
+	return Run(opts, generators, flags.Args()...)
+}
@@ -0,0 +1,54 @@
+package gen
+
+import (
+	"github.com/acorn-io/baaah/pkg/router"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-tools/pkg/genall"
+	"sigs.k8s.io/controller-tools/pkg/rbac"
+)
+
+// rbacGenerator generates the roles of the kubebuilder:rbac markers with the rules of the router added to the
+// ClusterRole.
+type rbacGenerator struct {
+	rbac.Generator
+	router *router.Router
+}
+
+func (g rbacGenerator) Generate(ctx *genall.GenerationContext) error {
+	objs, err := rbac.GenerateRoles(ctx, g.RoleName)
+	if err != nil {
+		return err
+	}
+	if g.router != nil {
+		objs = addClusterRoleRules(objs, g.RoleName, g.router.PolicyRules())
+	}
+	if len(objs) == 0 {
+		return nil
+	}
+	return ctx.WriteYAML("role.yaml", "", objs, genall.WithTransform(genall.TransformRemoveCreationTimestamp))
+}
+
+// addClusterRoleRules adds the rules to the ClusterRole in objs, which is added if there is none.
+func addClusterRoleRules(objs []any, name string, rules []rbacv1.PolicyRule) []any {
+	if len(rules) == 0 {
+		return objs
+	}
+	for i, obj := range objs {
+		if role, ok := obj.(rbacv1.ClusterRole); ok {
+			role.Rules = append(role.Rules, rules...)
+			objs[i] = role
+			return objs
+		}
+	}
+	return append([]any{rbacv1.ClusterRole{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ClusterRole",
+			APIVersion: rbacv1.SchemeGroupVersion.String(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Rules: rules,
+	}}, objs...)
+}
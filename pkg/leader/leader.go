@@ -4,8 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"os/signal"
-	"syscall"
+	"sync"
 	"time"
 
 	"github.com/acorn-io/baaah/pkg/log"
@@ -25,7 +24,23 @@ type OnNewLeader func(string)
 type ElectionConfig struct {
 	TTL                               time.Duration
 	Name, Namespace, ResourceLockType string
-	restCfg                           *rest.Config
+	// ReElect keeps the process running when leadership is lost. The context passed to the leader callback is canceled
+	// and this replica campaigns to become leader again. Otherwise, losing leadership exits the process.
+	ReElect bool
+	// IgnoreSignals leaves SIGTERM and SIGQUIT to the process. By default, the router catches them and stops
+	// gracefully, releasing the lease, and the process exits once main returns after waiting for the router to stop.
+	IgnoreSignals bool
+	// AllowStepDown allows POST /leader/step-down on the health server to release the lease of this election. The health
+	// server is not authenticated, so this should only be enabled when its port is not reachable by untrusted clients.
+	AllowStepDown bool
+	restCfg       *rest.Config
+
+	lock         sync.Mutex
+	id           string
+	resourceLock resourcelock.Interface
+	elector      *leaderelection.LeaderElector
+	stepDown     context.CancelFunc
+	steppingDown bool
 }
 
 func NewDefaultElectionConfig(namespace, name string, cfg *rest.Config) *ElectionConfig {
@@ -53,23 +68,35 @@ func NewElectionConfig(ttl time.Duration, namespace, name, lockType string, cfg
 }
 
 func (ec *ElectionConfig) Run(ctx context.Context, id string, onLeader OnLeader, onSwitchLeader OnNewLeader) error {
+	_, err := ec.Start(ctx, id, onLeader, onSwitchLeader)
+	return err
+}
+
+// Start runs leader election until the context is canceled. The returned channel is closed once leader election has
+// stopped and the lease, if held, has been released. When ctx is canceled, the context passed to onLeader is canceled
+// as well, so callers that need to finish work before the lease is released should cancel ctx only afterward.
+func (ec *ElectionConfig) Start(ctx context.Context, id string, onLeader OnLeader, onSwitchLeader OnNewLeader) (<-chan struct{}, error) {
+	done := make(chan struct{})
 	if ec == nil {
 		// Don't start leader election if there is no config.
-		return onLeader(ctx)
+		context.AfterFunc(ctx, func() {
+			close(done)
+		})
+		return done, onLeader(ctx)
 	}
 
 	if ec.Namespace == "" {
 		ec.Namespace = "kube-system"
 	}
 
-	if err := ec.run(ctx, id, onLeader, onSwitchLeader); err != nil {
-		return fmt.Errorf("failed to start leader election for %s: %v", ec.Name, err)
+	if err := ec.run(ctx, id, onLeader, onSwitchLeader, done); err != nil {
+		return nil, fmt.Errorf("failed to start leader election for %s: %v", ec.Name, err)
 	}
 
-	return nil
+	return done, nil
 }
 
-func (ec *ElectionConfig) run(ctx context.Context, id string, cb OnLeader, onSwitchLeader OnNewLeader) error {
+func (ec *ElectionConfig) run(ctx context.Context, id string, cb OnLeader, onSwitchLeader OnNewLeader, done chan struct{}) error {
 	rl, err := resourcelock.NewFromKubeconfig(
 		ec.ResourceLockType,
 		ec.Namespace,
@@ -84,59 +111,91 @@ func (ec *ElectionConfig) run(ctx context.Context, id string, cb OnLeader, onSwi
 		return fmt.Errorf("error creating leader lock for %s: %v", ec.Name, err)
 	}
 
-	// Catch these signals to ensure a graceful shutdown and leader election release.
-	sigCtx, cancel := signal.NotifyContext(ctx, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGKILL)
-	defer func() {
-		if err != nil {
-			// If we encountered an error, cancel the context because we won't be using it.
-			cancel()
-		}
-	}()
-
-	le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
-		Lock:          rl,
-		LeaseDuration: ec.TTL,
-		RenewDeadline: ec.TTL / 2,
-		RetryPeriod:   ec.TTL / 4,
-		Callbacks: leaderelection.LeaderCallbacks{
-			OnStartedLeading: func(ctx context.Context) {
-				if err := cb(ctx); err != nil {
-					log.Fatalf("leader callback error: %v", err)
-				}
-			},
-			OnNewLeader: onSwitchLeader,
-			OnStoppedLeading: func() {
-				select {
-				case <-sigCtx.Done():
-					// Must cancel so that the registered signals are no longer caught.
-					cancel()
-
-					// The context has been canceled or is otherwise complete.
-					// This is a request to terminate. Exit 0.
-					// Exiting cleanly is useful when the context is canceled
-					// so that Kubernetes doesn't record it exiting in error
-					// when the exit was requested. For example, the wrangler-cli
-					// package sets up a context that cancels when SIGTERM is
-					// sent in. If a node is shut down this is the type of signal
-					// sent. In that case you want the 0 exit code to mark it as
-					// complete so that everything comes back up correctly after
-					// a restart.
-					// The pattern found here can be found inside the kube-scheduler.
-					log.Infof("requested to terminate, exiting")
-					os.Exit(0)
-				default:
-					log.Fatalf("leader election lost for %s", ec.Name)
-				}
+	ec.lock.Lock()
+	ec.id = id
+	ec.resourceLock = rl
+	ec.lock.Unlock()
+
+	newElector := func(cancel context.CancelFunc) (*leaderelection.LeaderElector, error) {
+		return leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+			Lock:          rl,
+			LeaseDuration: ec.TTL,
+			RenewDeadline: ec.TTL / 2,
+			RetryPeriod:   ec.TTL / 4,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(ctx context.Context) {
+					if err := cb(ctx); err != nil {
+						if !ec.ReElect {
+							log.Fatalf("leader callback error: %v", err)
+						}
+						log.Errorf("leader callback error for %s, releasing leadership: %v", ec.Name, err)
+						cancel()
+					}
+				},
+				OnNewLeader: onSwitchLeader,
+				OnStoppedLeading: func() {
+					select {
+					case <-ctx.Done():
+						// This is a requested shutdown and the lease has been released.
+						log.Infof("stopped leader election for %s", ec.Name)
+					default:
+						if ec.isSteppingDown() {
+							log.Infof("stepped down as leader for %s", ec.Name)
+							return
+						}
+						if !ec.ReElect {
+							log.Fatalf("leader election lost for %s", ec.Name)
+						}
+						log.Warnf("leader election lost for %s, waiting to become leader again", ec.Name)
+					}
+				},
 			},
-		},
-		ReleaseOnCancel: true,
-	})
-	if err != nil {
+			ReleaseOnCancel: true,
+		})
+	}
+
+	// Validate the configuration before returning.
+	if _, err := newElector(func() {}); err != nil {
 		return err
 	}
 
 	go func() {
-		le.Run(sigCtx)
+		defer close(done)
+		for {
+			runCtx, cancel := context.WithCancel(ctx)
+			le, err := newElector(cancel)
+			if err != nil {
+				cancel()
+				log.Errorf("failed to create leader elector for %s: %v", ec.Name, err)
+				return
+			}
+			ec.lock.Lock()
+			ec.elector, ec.stepDown = le, cancel
+			ec.lock.Unlock()
+
+			le.Run(runCtx)
+			cancel()
+
+			ec.lock.Lock()
+			steppedDown := ec.steppingDown
+			ec.elector, ec.stepDown, ec.steppingDown = nil, nil, false
+			ec.lock.Unlock()
+
+			if (!ec.ReElect && !steppedDown) || ctx.Err() != nil {
+				return
+			}
+
+			// After stepping down, wait long enough for another replica to acquire the lease.
+			wait := ec.TTL / 4
+			if steppedDown {
+				wait = ec.TTL
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
 	}()
 	return nil
 }
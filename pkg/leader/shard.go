@@ -0,0 +1,359 @@
+package leader
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/acorn-io/baaah/pkg/log"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	coordinationclient "k8s.io/client-go/kubernetes/typed/coordination/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	shardGroupLabel = "baaah.acorn.io/shard-group"
+	shardRoleLabel  = "baaah.acorn.io/shard-role"
+	shardIndexLabel = "baaah.acorn.io/shard-index"
+
+	shardRole  = "shard"
+	memberRole = "member"
+)
+
+// OnShardsChanged is called with the sorted indexes of the shards held by this replica whenever they change. Before a
+// shard is released, it is called without that shard and must return once the keys of the shard are no longer being
+// handled or ctx is done.
+type OnShardsChanged func(ctx context.Context, owned []int)
+
+// ShardConfig splits the keys of a router into shards that are spread across replicas. Each shard is owned by the
+// replica holding its Lease. Each replica also holds a membership Lease so that the shards can be rebalanced evenly
+// when replicas come and go.
+type ShardConfig struct {
+	TTL             time.Duration
+	Name, Namespace string
+	Shards          int
+	// ByNamespace assigns all objects in a namespace to the same shard. Otherwise, objects are assigned by their key.
+	ByNamespace bool
+	restCfg     *rest.Config
+}
+
+func NewShardConfig(namespace, name string, shards int, cfg *rest.Config) *ShardConfig {
+	ttl := defaultLeaderTTL
+	if os.Getenv("BAAAH_DEV_MODE") != "" {
+		ttl = devLeaderTTL
+	}
+	return &ShardConfig{
+		TTL:       ttl,
+		Namespace: namespace,
+		Name:      name,
+		Shards:    shards,
+		restCfg:   cfg,
+	}
+}
+
+// ShardFor returns the shard of the object with the given namespace and name. Cluster scoped objects are always
+// assigned by name.
+func (sc *ShardConfig) ShardFor(namespace, name string) int {
+	h := fnv.New32a()
+	if sc.ByNamespace && namespace != "" {
+		_, _ = h.Write([]byte(namespace))
+	} else {
+		_, _ = h.Write([]byte(namespace + "/" + name))
+	}
+	return int(h.Sum32() % uint32(sc.Shards))
+}
+
+// Start acquires and renews shard Leases until the context is canceled. The returned channel is closed once all Leases
+// held by this replica have been released.
+func (sc *ShardConfig) Start(ctx context.Context, id string, onChange OnShardsChanged) (<-chan struct{}, error) {
+	if sc.Shards <= 0 {
+		return nil, fmt.Errorf("shards for %s must be positive, got %d", sc.Name, sc.Shards)
+	}
+	if sc.Namespace == "" {
+		sc.Namespace = "kube-system"
+	}
+
+	client, err := kubernetes.NewForConfig(sc.restCfg)
+	if err != nil {
+		return nil, fmt.Errorf("error creating shard lease client for %s: %v", sc.Name, err)
+	}
+
+	e := &shardElector{
+		cfg:      sc,
+		id:       id,
+		leases:   client.CoordinationV1().Leases(sc.Namespace),
+		owned:    map[int]time.Time{},
+		onChange: onChange,
+	}
+
+	done := make(chan struct{})
+	go e.run(ctx, done)
+	return done, nil
+}
+
+type shardElector struct {
+	cfg    *ShardConfig
+	id     string
+	leases coordinationclient.LeaseInterface
+	// owned is the time each held shard was last renewed
+	owned    map[int]time.Time
+	onChange OnShardsChanged
+	// notified is the set of shards last passed to onChange
+	notified []int
+}
+
+func (e *shardElector) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(e.cfg.TTL / 4)
+	defer ticker.Stop()
+
+	for {
+		e.reconcile(ctx)
+
+		select {
+		case <-ctx.Done():
+			e.releaseAll()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *shardElector) reconcile(ctx context.Context) {
+	now := time.Now()
+	if err := e.renewMember(ctx, now); err != nil {
+		log.Errorf("failed to renew shard membership of %s for %s: %v", e.id, e.cfg.Name, err)
+	}
+
+	list, err := e.leases.List(ctx, metav1.ListOptions{
+		LabelSelector: shardGroupLabel + "=" + e.cfg.Name,
+	})
+	if err != nil {
+		log.Errorf("failed to list shard leases for %s: %v", e.cfg.Name, err)
+		e.dropStale(ctx, now)
+		return
+	}
+
+	var (
+		members int
+		shards  = map[int]*coordinationv1.Lease{}
+	)
+	for i := range list.Items {
+		lease := &list.Items[i]
+		switch lease.Labels[shardRoleLabel] {
+		case memberRole:
+			if !expired(lease, now) {
+				members++
+			}
+		case shardRole:
+			index, err := strconv.Atoi(lease.Labels[shardIndexLabel])
+			if err == nil && index >= 0 && index < e.cfg.Shards {
+				shards[index] = lease
+			}
+		}
+	}
+	if members == 0 {
+		members = 1
+	}
+	target := (e.cfg.Shards + members - 1) / members
+
+	// Renew the shards that are still held, dropping those taken over by another replica.
+	for index := range e.owned {
+		lease, ok := shards[index]
+		if !ok || ptr.Deref(lease.Spec.HolderIdentity, "") != e.id {
+			log.Infof("Lost shard %d of %s", index, e.cfg.Name)
+			delete(e.owned, index)
+			continue
+		}
+		lease.Spec.RenewTime = &metav1.MicroTime{Time: now}
+		if updated, err := e.leases.Update(ctx, lease, metav1.UpdateOptions{}); err != nil {
+			log.Errorf("failed to renew shard %d of %s: %v", index, e.cfg.Name, err)
+		} else {
+			shards[index] = updated
+			e.owned[index] = now
+		}
+	}
+	e.dropStale(ctx, now)
+
+	// Release the highest shards if more than an even share is held so that new replicas can acquire them. The keys of
+	// those shards must no longer be handled here before another replica can acquire them.
+	var released []*coordinationv1.Lease
+	for len(e.owned) > target {
+		index := slices.Max(e.ownedShards())
+		released = append(released, shards[index])
+		delete(e.owned, index)
+	}
+	e.notify(ctx)
+	for _, lease := range released {
+		e.release(ctx, lease)
+	}
+
+	for index := 0; index < e.cfg.Shards && len(e.owned) < target; index++ {
+		if _, ok := e.owned[index]; ok {
+			continue
+		}
+		if e.acquire(ctx, index, shards[index], now) {
+			e.owned[index] = now
+		}
+	}
+	e.notify(ctx)
+}
+
+// dropStale forgets shards that could not be renewed in time, because another replica may acquire them.
+func (e *shardElector) dropStale(ctx context.Context, now time.Time) {
+	for index, renewed := range e.owned {
+		if now.Sub(renewed) > e.cfg.TTL/2 {
+			log.Infof("Failed to renew shard %d of %s in time", index, e.cfg.Name)
+			delete(e.owned, index)
+		}
+	}
+	e.notify(ctx)
+}
+
+// notify calls onChange if the held shards changed since it was last called. Waiting for the keys of lost shards is
+// limited to half the TTL so that the remaining shards are renewed in time.
+func (e *shardElector) notify(ctx context.Context) {
+	owned := e.ownedShards()
+	if slices.Equal(owned, e.notified) {
+		return
+	}
+	log.Infof("Shards of %s held by %s: %v", e.cfg.Name, e.id, owned)
+
+	ctx, cancel := context.WithTimeout(ctx, e.cfg.TTL/2)
+	defer cancel()
+	e.onChange(ctx, owned)
+	e.notified = owned
+}
+
+func (e *shardElector) ownedShards() []int {
+	result := make([]int, 0, len(e.owned))
+	for index := range e.owned {
+		result = append(result, index)
+	}
+	slices.Sort(result)
+	return result
+}
+
+func (e *shardElector) acquire(ctx context.Context, index int, lease *coordinationv1.Lease, now time.Time) bool {
+	if lease == nil {
+		lease = e.newLease(e.shardName(index), shardRole, now)
+		lease.Labels[shardIndexLabel] = strconv.Itoa(index)
+		_, err := e.leases.Create(ctx, lease, metav1.CreateOptions{})
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			log.Errorf("failed to create shard %d of %s: %v", index, e.cfg.Name, err)
+		}
+		return err == nil
+	}
+
+	if ptr.Deref(lease.Spec.HolderIdentity, "") != "" && !expired(lease, now) {
+		return false
+	}
+
+	lease = lease.DeepCopy()
+	lease.Spec.HolderIdentity = &e.id
+	lease.Spec.LeaseDurationSeconds = ptr.To(int32(e.cfg.TTL.Seconds()))
+	lease.Spec.AcquireTime = &metav1.MicroTime{Time: now}
+	lease.Spec.RenewTime = &metav1.MicroTime{Time: now}
+	lease.Spec.LeaseTransitions = ptr.To(ptr.Deref(lease.Spec.LeaseTransitions, 0) + 1)
+	// A conflict means another replica acquired the shard first.
+	_, err := e.leases.Update(ctx, lease, metav1.UpdateOptions{})
+	if err != nil && !apierrors.IsConflict(err) {
+		log.Errorf("failed to acquire shard %d of %s: %v", index, e.cfg.Name, err)
+	}
+	return err == nil
+}
+
+func (e *shardElector) release(ctx context.Context, lease *coordinationv1.Lease) {
+	if lease == nil {
+		return
+	}
+	lease = lease.DeepCopy()
+	lease.Spec.HolderIdentity = nil
+	lease.Spec.AcquireTime = nil
+	lease.Spec.RenewTime = nil
+	if _, err := e.leases.Update(ctx, lease, metav1.UpdateOptions{}); err != nil {
+		log.Errorf("failed to release lease %s: %v", lease.Name, err)
+	}
+}
+
+// releaseAll releases every shard held and removes the membership of this replica. This is called after the context
+// is canceled, so it uses a new context limited to the renew deadline.
+func (e *shardElector) releaseAll() {
+	ctx, cancel := context.WithTimeout(context.Background(), e.cfg.TTL/2)
+	defer cancel()
+
+	held := e.ownedShards()
+	e.owned = map[int]time.Time{}
+	e.notify(ctx)
+
+	for _, index := range held {
+		lease, err := e.leases.Get(ctx, e.shardName(index), metav1.GetOptions{})
+		if err != nil {
+			log.Errorf("failed to release shard %d of %s: %v", index, e.cfg.Name, err)
+			continue
+		}
+		if ptr.Deref(lease.Spec.HolderIdentity, "") == e.id {
+			e.release(ctx, lease)
+		}
+	}
+
+	if err := e.leases.Delete(ctx, e.memberName(), metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		log.Errorf("failed to remove shard membership of %s for %s: %v", e.id, e.cfg.Name, err)
+	}
+}
+
+func (e *shardElector) renewMember(ctx context.Context, now time.Time) error {
+	lease, err := e.leases.Get(ctx, e.memberName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = e.leases.Create(ctx, e.newLease(e.memberName(), memberRole, now), metav1.CreateOptions{})
+		return err
+	} else if err != nil {
+		return err
+	}
+	lease.Spec.RenewTime = &metav1.MicroTime{Time: now}
+	_, err = e.leases.Update(ctx, lease, metav1.UpdateOptions{})
+	return err
+}
+
+func (e *shardElector) newLease(name, role string, now time.Time) *coordinationv1.Lease {
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: e.cfg.Namespace,
+			Labels: map[string]string{
+				shardGroupLabel: e.cfg.Name,
+				shardRoleLabel:  role,
+			},
+		},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &e.id,
+			LeaseDurationSeconds: ptr.To(int32(e.cfg.TTL.Seconds())),
+			AcquireTime:          &metav1.MicroTime{Time: now},
+			RenewTime:            &metav1.MicroTime{Time: now},
+		},
+	}
+}
+
+func (e *shardElector) shardName(index int) string {
+	return fmt.Sprintf("%s-shard-%d", e.cfg.Name, index)
+}
+
+func (e *shardElector) memberName() string {
+	return fmt.Sprintf("%s-member-%s", e.cfg.Name, e.id)
+}
+
+func expired(lease *coordinationv1.Lease, now time.Time) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	return lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second).Before(now)
+}
@@ -0,0 +1,70 @@
+package leader
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Status is the state of the lease used for leader election.
+type Status struct {
+	Name          string        `json:"name"`
+	Namespace     string        `json:"namespace"`
+	Identity      string        `json:"identity"`
+	Holder        string        `json:"holder"`
+	IsLeader      bool          `json:"isLeader"`
+	AcquireTime   time.Time     `json:"acquireTime"`
+	RenewTime     time.Time     `json:"renewTime"`
+	LeaseDuration time.Duration `json:"leaseDuration"`
+	Transitions   int           `json:"transitions"`
+}
+
+// Status returns the current holder of the lease and when it was acquired and last renewed.
+func (ec *ElectionConfig) Status(ctx context.Context) (Status, error) {
+	ec.lock.Lock()
+	rl, id := ec.resourceLock, ec.id
+	ec.lock.Unlock()
+
+	if rl == nil {
+		return Status{}, fmt.Errorf("leader election for %s has not been started", ec.Name)
+	}
+
+	record, _, err := rl.Get(ctx)
+	if err != nil {
+		return Status{}, err
+	}
+
+	return Status{
+		Name:          ec.Name,
+		Namespace:     ec.Namespace,
+		Identity:      id,
+		Holder:        record.HolderIdentity,
+		IsLeader:      record.HolderIdentity == id,
+		AcquireTime:   record.AcquireTime.Time,
+		RenewTime:     record.RenewTime.Time,
+		LeaseDuration: time.Duration(record.LeaseDurationSeconds) * time.Second,
+		Transitions:   record.LeaderTransitions,
+	}, nil
+}
+
+// StepDown releases the lease if this replica is the leader so that another replica can take over without waiting
+// for the lease to expire. The context passed to the leader callback is canceled and this replica campaigns to become
+// leader again after the lease duration. It returns false if this replica is not the leader.
+func (ec *ElectionConfig) StepDown() bool {
+	ec.lock.Lock()
+	defer ec.lock.Unlock()
+
+	if ec.elector == nil || ec.stepDown == nil || !ec.elector.IsLeader() {
+		return false
+	}
+
+	ec.steppingDown = true
+	ec.stepDown()
+	return true
+}
+
+func (ec *ElectionConfig) isSteppingDown() bool {
+	ec.lock.Lock()
+	defer ec.lock.Unlock()
+	return ec.steppingDown
+}
@@ -0,0 +1,91 @@
+package metadata
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// List wraps a typed list so that it is read from a metadata-only cache. Only the TypeMeta and ObjectMeta of the
+// items are populated. Triggers registered by this list will only watch the metadata of the kind.
+func List(obj kclient.ObjectList) kclient.ObjectList {
+	return &HolderList{
+		ObjectList: obj,
+	}
+}
+
+// Get wraps a typed object so that it is read from a metadata-only cache. Only the TypeMeta and ObjectMeta of the
+// object are populated. Triggers registered by this object will only watch the metadata of the kind.
+func Get(obj kclient.Object) kclient.Object {
+	return &Holder{
+		Object: obj,
+	}
+}
+
+func IsWrapped(obj runtime.Object) bool {
+	if _, ok := obj.(*Holder); ok {
+		return true
+	}
+	if _, ok := obj.(*HolderList); ok {
+		return true
+	}
+	return false
+}
+
+// IsMetadataOnly returns true if the object is wrapped or is a PartialObjectMetadata(List).
+func IsMetadataOnly(obj runtime.Object) bool {
+	switch obj.(type) {
+	case *Holder, *HolderList, *metav1.PartialObjectMetadata, *metav1.PartialObjectMetadataList:
+		return true
+	}
+	return false
+}
+
+func Unwrap(obj runtime.Object) runtime.Object {
+	if h, ok := obj.(*Holder); ok {
+		return h.Object
+	}
+	if h, ok := obj.(*HolderList); ok {
+		return h.ObjectList
+	}
+	return obj
+}
+
+func UnwrapList(obj kclient.ObjectList) kclient.ObjectList {
+	if h, ok := obj.(*HolderList); ok {
+		return h.ObjectList
+	}
+	return obj
+}
+
+// NewPartialObject returns an empty PartialObjectMetadata for the given kind.
+func NewPartialObject(gvk schema.GroupVersionKind) *metav1.PartialObjectMetadata {
+	obj := &metav1.PartialObjectMetadata{}
+	obj.SetGroupVersionKind(gvk)
+	return obj
+}
+
+// NewPartialObjectList returns an empty PartialObjectMetadataList for the given kind. The gvk is the kind of the items,
+// not the list.
+func NewPartialObjectList(gvk schema.GroupVersionKind) *metav1.PartialObjectMetadataList {
+	obj := &metav1.PartialObjectMetadataList{}
+	obj.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+	return obj
+}
+
+type Holder struct {
+	kclient.Object
+}
+
+func (h *Holder) DeepCopyObject() runtime.Object {
+	return &Holder{Object: h.Object.DeepCopyObject().(kclient.Object)}
+}
+
+type HolderList struct {
+	kclient.ObjectList
+}
+
+func (h *HolderList) DeepCopyObject() runtime.Object {
+	return &HolderList{ObjectList: h.ObjectList.DeepCopyObject().(kclient.ObjectList)}
+}
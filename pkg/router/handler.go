@@ -2,7 +2,9 @@ package router
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -25,6 +27,12 @@ import (
 const (
 	TriggerPrefix = "_t "
 	ReplayPrefix  = "_r "
+
+	// DefaultUnwatchGracePeriod is how long a kind must go unused by handlers and triggers before it is no longer
+	// watched.
+	DefaultUnwatchGracePeriod = 5 * time.Minute
+	// DefaultShutdownTimeout is how long to wait for running handlers to finish when the HandlerSet is shut down.
+	DefaultShutdownTimeout = 30 * time.Second
 )
 
 type HandlerSet struct {
@@ -37,13 +45,26 @@ type HandlerSet struct {
 	save     save
 	onError  ErrorHandler
 
-	watchingLock sync.Mutex
-	watching     map[schema.GroupVersionKind]bool
-	locker       locker.Locker
+	watchingLock       sync.Mutex
+	watching           map[schema.GroupVersionKind]context.CancelCauseFunc
+	watchingMetadata   map[schema.GroupVersionKind]context.CancelCauseFunc
+	unwatchGracePeriod time.Duration
+	shutdownTimeout    time.Duration
+	locker             locker.Locker
 
 	limiterLock sync.Mutex
 	limiters    map[limiterKey]*rate.Limiter
-	waiting     map[limiterKey]struct{}
+	waiting     map[limiterKey]*time.Timer
+
+	resyncLock sync.Mutex
+	resyncs    []resync
+	resyncCtx  context.Context
+
+	sharding *sharding
+
+	reconcileTimeout time.Duration
+	handlerTimeout   time.Duration
+	watchdog         watchdog
 }
 
 type limiterKey struct {
@@ -70,7 +91,14 @@ func NewHandlerSet(name string, scheme *runtime.Scheme, backend backend.Backend)
 			cache:  backend,
 			client: backend,
 		},
-		watching: map[schema.GroupVersionKind]bool{},
+		watching:           map[schema.GroupVersionKind]context.CancelCauseFunc{},
+		watchingMetadata:   map[schema.GroupVersionKind]context.CancelCauseFunc{},
+		unwatchGracePeriod: DefaultUnwatchGracePeriod,
+		shutdownTimeout:    DefaultShutdownTimeout,
+		reconcileTimeout:   DefaultReconcileTimeout,
+		watchdog: watchdog{
+			threshold: DefaultStuckThreshold,
+		},
 	}
 	hs.triggers.watcher = hs
 	return hs
@@ -78,10 +106,21 @@ func NewHandlerSet(name string, scheme *runtime.Scheme, backend backend.Backend)
 
 func (m *HandlerSet) Start(ctx context.Context) error {
 	m.ctx = ctx
+
+	// The watches of a previous start, before leadership was lost, ended with its context.
+	m.watchingLock.Lock()
+	m.watching = map[schema.GroupVersionKind]context.CancelCauseFunc{}
+	m.watchingMetadata = map[schema.GroupVersionKind]context.CancelCauseFunc{}
+	m.watchingLock.Unlock()
 	if err := m.WatchGVK(m.handlers.GVKs()...); err != nil {
 		return err
 	}
-	return m.backend.Start(ctx)
+	if err := m.backend.Start(ctx); err != nil {
+		return err
+	}
+	m.startResyncs(ctx)
+	m.startWatchdog(ctx)
+	return nil
 }
 
 func toObject(obj runtime.Object) kclient.Object {
@@ -114,7 +153,7 @@ func (t *triggerRegistry) Watch(obj runtime.Object, namespace, name string, sel
 	return nil
 }
 
-func (m *HandlerSet) newRequestResponse(gvk schema.GroupVersionKind, key string, runtimeObject runtime.Object, trigger bool) (Request, *response, error) {
+func (m *HandlerSet) newRequestResponse(ctx context.Context, gvk schema.GroupVersionKind, key string, runtimeObject runtime.Object, trigger bool) (Request, *response, error) {
 	var (
 		obj = toObject(runtimeObject)
 	)
@@ -154,7 +193,7 @@ func (m *HandlerSet) newRequestResponse(gvk schema.GroupVersionKind, key string,
 				registry: triggerRegistry,
 			},
 		},
-		Ctx:       m.ctx,
+		Ctx:       ctx,
 		GVK:       gvk,
 		Object:    obj,
 		Namespace: ns,
@@ -173,16 +212,81 @@ func (m *HandlerSet) AddHandler(objType kclient.Object, handler Handler) {
 	m.handlers.AddHandler(gvk, handler)
 }
 
+// SetUnwatchGracePeriod sets how long a kind must go unused by handlers and triggers before it is no longer watched.
+// A negative value disables unwatching kinds.
+func (m *HandlerSet) SetUnwatchGracePeriod(d time.Duration) {
+	m.unwatchGracePeriod = d
+}
+
+// SetShutdownTimeout sets how long Shutdown waits for running handlers to finish.
+func (m *HandlerSet) SetShutdownTimeout(d time.Duration) {
+	m.shutdownTimeout = d
+}
+
+// Shutdown stops processing new keys and waits, for at most the shutdown timeout, for the handlers that are running to
+// finish. Keys that are backing off are dropped; they will be processed again when the objects are next listed.
+func (m *HandlerSet) Shutdown(ctx context.Context) error {
+	var err error
+	if d, ok := m.backend.(backend.Drainer); ok {
+		ctx, cancel := context.WithTimeout(ctx, m.shutdownTimeout)
+		defer cancel()
+		err = d.Drain(ctx)
+	}
+
+	m.limiterLock.Lock()
+	defer m.limiterLock.Unlock()
+	for _, timer := range m.waiting {
+		timer.Stop()
+	}
+	m.waiting = nil
+
+	return err
+}
+
 func (m *HandlerSet) WatchGVK(gvks ...schema.GroupVersionKind) error {
 	var watchErrs []error
 	m.watchingLock.Lock()
 	for _, gvk := range gvks {
-		if m.watching[gvk] {
+		if _, ok := m.watching[gvk]; ok {
+			continue
+		}
+		ctx, cancel := context.WithCancelCause(m.ctx)
+		if err := m.backend.Watcher(ctx, gvk, m.name, m.onChange); err == nil {
+			m.watching[gvk] = cancel
+			if m.handlers.HandlesGVK(gvk) {
+				m.cancelOnDelete(ctx, gvk)
+			}
+		} else {
+			cancel(backend.ErrUnwatched)
+			watchErrs = append(watchErrs, err)
+		}
+	}
+	m.watchingLock.Unlock()
+	return merr.NewErrors(watchErrs...)
+}
+
+// WatchMetadataGVK watches the kinds caching only the metadata of the objects. This is used for triggers that only
+// need the name, namespace, and labels of an object. If the kind is already fully watched, this is a no-op.
+func (m *HandlerSet) WatchMetadataGVK(gvks ...schema.GroupVersionKind) error {
+	mw, ok := m.backend.(backend.MetadataWatcher)
+	if !ok {
+		return m.WatchGVK(gvks...)
+	}
+
+	var watchErrs []error
+	m.watchingLock.Lock()
+	for _, gvk := range gvks {
+		if _, ok := m.watching[gvk]; ok {
 			continue
 		}
-		if err := m.backend.Watcher(m.ctx, gvk, m.name, m.onChange); err == nil {
-			m.watching[gvk] = true
+		if _, ok := m.watchingMetadata[gvk]; ok {
+			continue
+		}
+		ctx, cancel := context.WithCancelCause(m.ctx)
+		if err := mw.MetadataWatcher(ctx, gvk, m.name, m.onMetadataChange); err == nil {
+			m.watchingMetadata[gvk] = cancel
 		} else {
+			cancel(backend.ErrUnwatched)
 			watchErrs = append(watchErrs, err)
 		}
 	}
@@ -190,6 +294,106 @@ func (m *HandlerSet) WatchGVK(gvks ...schema.GroupVersionKind) error {
 	return merr.NewErrors(watchErrs...)
 }
 
+// kindStatus returns whether the cache of each watched kind has synced.
+func (m *HandlerSet) kindStatus(ctx context.Context) []kindStatus {
+	m.watchingLock.Lock()
+	gvks := maps.Keys(m.watching)
+	m.watchingLock.Unlock()
+
+	sc, ok := m.backend.(backend.SyncChecker)
+	if !ok {
+		return nil
+	}
+
+	result := make([]kindStatus, 0, len(gvks))
+	for _, gvk := range gvks {
+		synced, err := sc.HasSynced(ctx, gvk)
+		status := kindStatus{
+			Kind:   gvk.String(),
+			Synced: synced,
+		}
+		if err != nil {
+			status.Error = err.Error()
+		}
+		result = append(result, status)
+	}
+	slices.SortFunc(result, func(a, b kindStatus) int {
+		return strings.Compare(a.Kind, b.Kind)
+	})
+	return result
+}
+
+func (m *HandlerSet) isWatching(gvk schema.GroupVersionKind) bool {
+	m.watchingLock.Lock()
+	defer m.watchingLock.Unlock()
+	_, ok := m.watching[gvk]
+	return ok
+}
+
+// Unwatch stops watching the kinds after the grace period if they are still not handled by any route or targeted by
+// any trigger. The backend will stop the informer for a kind once no handler set is watching it.
+func (m *HandlerSet) Unwatch(gvks ...schema.GroupVersionKind) {
+	if m.unwatchGracePeriod < 0 {
+		return
+	}
+	for _, gvk := range gvks {
+		if m.handlers.HandlesGVK(gvk) {
+			continue
+		}
+		time.AfterFunc(m.unwatchGracePeriod, func() {
+			m.unwatchIfUnused(gvk)
+		})
+	}
+}
+
+func (m *HandlerSet) unwatchIfUnused(gvk schema.GroupVersionKind) {
+	if m.ctx.Err() != nil {
+		return
+	}
+
+	// Hold the watching lock while checking the triggers so that a trigger registered concurrently will watch the
+	// kind again after it is removed here.
+	m.watchingLock.Lock()
+	defer m.watchingLock.Unlock()
+
+	if m.handlers.HandlesGVK(gvk) || m.triggers.IsWatched(gvk) {
+		return
+	}
+
+	for _, watching := range []map[schema.GroupVersionKind]context.CancelCauseFunc{m.watching, m.watchingMetadata} {
+		if cancel, ok := watching[gvk]; ok {
+			log.Debugf("No longer watching unused [%v]", gvk)
+			cancel(backend.ErrUnwatched)
+			delete(watching, gvk)
+		}
+	}
+}
+
+// onMetadataChange only invokes triggers because handlers are never registered for metadata-only watches. If the kind
+// has since been fully watched, the full watch will invoke the triggers instead.
+func (m *HandlerSet) onMetadataChange(gvk schema.GroupVersionKind, key string, runtimeObject runtime.Object) (runtime.Object, error) {
+	if m.isWatching(gvk) || strings.HasPrefix(key, TriggerPrefix) || strings.HasPrefix(key, ReplayPrefix) {
+		return runtimeObject, nil
+	}
+	return m.onlyTrigger(gvk, key, runtimeObject)
+}
+
+// onlyTrigger invokes the triggers for the object without handling it.
+func (m *HandlerSet) onlyTrigger(gvk schema.GroupVersionKind, key string, runtimeObject runtime.Object) (runtime.Object, error) {
+	req, _, err := m.newRequestResponse(m.ctx, gvk, key, runtimeObject, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if runtimeObject == nil {
+		m.triggers.UnregisterAndTrigger(req)
+	} else {
+		m.triggers.Trigger(req)
+	}
+
+	return runtimeObject, nil
+}
+
 func (m *HandlerSet) checkDelay(gvk schema.GroupVersionKind, key string) bool {
 	m.limiterLock.Lock()
 	defer m.limiterLock.Unlock()
@@ -214,17 +418,19 @@ func (m *HandlerSet) checkDelay(gvk schema.GroupVersionKind, key string) bool {
 	delay := limit.Reserve().Delay()
 	if delay > 0 {
 		if m.waiting == nil {
-			m.waiting = map[limiterKey]struct{}{}
+			m.waiting = map[limiterKey]*time.Timer{}
 		}
-		m.waiting[lKey] = struct{}{}
-		go func() {
-			log.Debugf("Backing off [%s] [%s] for %s", key, gvk, delay)
-			time.Sleep(delay)
+		log.Debugf("Backing off [%s] [%s] for %s", key, gvk, delay)
+		m.waiting[lKey] = time.AfterFunc(delay, func() {
 			m.limiterLock.Lock()
 			defer m.limiterLock.Unlock()
+			if _, ok := m.waiting[lKey]; !ok {
+				// Shutdown stopped the timer after it fired
+				return
+			}
 			delete(m.waiting, lKey)
 			_ = m.backend.Trigger(gvk, ReplayPrefix+key, 0)
-		}()
+		})
 		return false
 	}
 
@@ -250,6 +456,16 @@ func (m *HandlerSet) onChange(gvk schema.GroupVersionKind, key string, runtimeOb
 		key = strings.TrimPrefix(key, ReplayPrefix)
 	}
 
+	doneHandling, owned := m.sharding.begin(key)
+	if !owned {
+		if fromReplay || fromTrigger {
+			return runtimeObject, nil
+		}
+		// Another replica handles this key, but it may trigger keys handled here.
+		return m.onlyTrigger(gvk, key, runtimeObject)
+	}
+	defer doneHandling()
+
 	if !fromReplay && !fromTrigger {
 		// Process delay have key has be reassigned from the TriggerPrefix
 		if !m.checkDelay(gvk, key) {
@@ -257,6 +473,9 @@ func (m *HandlerSet) onChange(gvk schema.GroupVersionKind, key string, runtimeOb
 		}
 	}
 
+	ctx, done := m.startReconcile(gvk, key)
+	defer done()
+
 	obj, err := m.scheme.New(gvk)
 	if err != nil {
 		return nil, err
@@ -272,7 +491,7 @@ func (m *HandlerSet) onChange(gvk schema.GroupVersionKind, key string, runtimeOb
 	m.locker.Lock(lockKey)
 	defer func() { _ = m.locker.Unlock(lockKey) }()
 
-	err = m.backend.Get(m.ctx, kclient.ObjectKey{Name: name, Namespace: ns}, obj.(kclient.Object))
+	err = m.backend.Get(ctx, kclient.ObjectKey{Name: name, Namespace: ns}, obj.(kclient.Object))
 	if err == nil {
 		runtimeObject = obj
 	} else if !apierror.IsNotFound(err) {
@@ -283,7 +502,13 @@ func (m *HandlerSet) onChange(gvk schema.GroupVersionKind, key string, runtimeOb
 		m.forgetBackoff(gvk, key)
 	}
 
-	return m.handle(gvk, key, runtimeObject, fromTrigger)
+	runtimeObject, err = m.handle(ctx, gvk, key, runtimeObject, fromTrigger)
+	if err != nil && errors.Is(context.Cause(ctx), ErrObjectDeleted) {
+		// The deletion has been enqueued and will be handled next.
+		log.Debugf("Reconcile of [%s] [%v] was canceled: %v", key, gvk, err)
+		return nil, nil
+	}
+	return runtimeObject, err
 }
 
 func (m *HandlerSet) handleError(req Request, resp Response, err error) error {
@@ -293,8 +518,8 @@ func (m *HandlerSet) handleError(req Request, resp Response, err error) error {
 	return err
 }
 
-func (m *HandlerSet) handle(gvk schema.GroupVersionKind, key string, unmodifiedObject runtime.Object, trigger bool) (runtime.Object, error) {
-	req, resp, err := m.newRequestResponse(gvk, key, unmodifiedObject, trigger)
+func (m *HandlerSet) handle(ctx context.Context, gvk schema.GroupVersionKind, key string, unmodifiedObject runtime.Object, trigger bool) (runtime.Object, error) {
+	req, resp, err := m.newRequestResponse(ctx, gvk, key, unmodifiedObject, trigger)
 	if err != nil {
 		return nil, err
 	}
@@ -24,6 +24,8 @@ type handlers struct {
 }
 
 func (h *handlers) GVKs() (result []schema.GroupVersionKind) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
 	for gvk := range h.handlers {
 		result = append(result, gvk)
 	}
@@ -37,9 +39,13 @@ func (h *handlers) AddHandler(gvk schema.GroupVersionKind, handler Handler) {
 }
 
 func (h *handlers) Handles(req Request) bool {
+	return h.HandlesGVK(req.GVK)
+}
+
+func (h *handlers) HandlesGVK(gvk schema.GroupVersionKind) bool {
 	h.lock.RLock()
 	defer h.lock.RUnlock()
-	return len(h.handlers[req.GVK]) > 0
+	return len(h.handlers[gvk]) > 0
 }
 
 func (h *handlers) Handle(req Request, resp *response) error {
@@ -2,25 +2,51 @@ package router
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
-	"os/signal"
+	"slices"
+	"strings"
 	"sync"
-	"syscall"
 
+	"github.com/acorn-io/baaah/pkg/leader"
 	"github.com/acorn-io/baaah/pkg/log"
 )
 
+// HealthCheck is a check served by the health server. A nil error is healthy.
+type HealthCheck func(ctx context.Context) error
+
 var healthz struct {
-	healths map[string]bool
-	started bool
-	lock    *sync.RWMutex
-	port    int
+	ready       map[string]bool
+	routers     map[string]*Router
+	readyChecks map[string]HealthCheck
+	liveChecks  map[string]HealthCheck
+	elections   []*leader.ElectionConfig
+	started     bool
+	lock        *sync.RWMutex
+	port        int
 }
 
 func init() {
 	healthz.lock = &sync.RWMutex{}
-	healthz.healths = make(map[string]bool)
+	healthz.ready = make(map[string]bool)
+	healthz.routers = make(map[string]*Router)
+	healthz.readyChecks = make(map[string]HealthCheck)
+	healthz.liveChecks = make(map[string]HealthCheck)
+}
+
+// AddReadyzCheck adds a check to /readyz and /healthz. A check with the same name is replaced.
+func AddReadyzCheck(name string, check HealthCheck) {
+	healthz.lock.Lock()
+	defer healthz.lock.Unlock()
+	healthz.readyChecks[name] = check
+}
+
+// AddLivezCheck adds a check to /livez. A check with the same name is replaced.
+func AddLivezCheck(name string, check HealthCheck) {
+	healthz.lock.Lock()
+	defer healthz.lock.Unlock()
+	healthz.liveChecks[name] = check
 }
 
 func setPort(port int) {
@@ -33,25 +59,225 @@ func setPort(port int) {
 	healthz.port = port
 }
 
-func setHealthy(name string, healthy bool) {
+// setReady sets whether the router is ready. A router is ready when it is not the leader, or when it is the leader and
+// its handlers are started and caches are synced.
+func setReady(name string, ready bool) {
+	healthz.lock.Lock()
+	defer healthz.lock.Unlock()
+	healthz.ready[name] = ready
+}
+
+func addRouter(r *Router) {
 	healthz.lock.Lock()
 	defer healthz.lock.Unlock()
-	healthz.healths[name] = healthy
+	if _, ok := healthz.routers[r.name]; ok {
+		log.Warnf("multiple routers are named %q, only the last one will be reported by the health server", r.name)
+	}
+	healthz.routers[r.name] = r
 }
 
-func getHealthy() bool {
+func addElection(ec *leader.ElectionConfig) {
+	healthz.lock.Lock()
+	defer healthz.lock.Unlock()
+	healthz.elections = append(healthz.elections, ec)
+}
+
+func getElections(name string) []*leader.ElectionConfig {
 	healthz.lock.RLock()
 	defer healthz.lock.RUnlock()
-	for _, healthy := range healthz.healths {
-		if !healthy {
-			return false
+	var result []*leader.ElectionConfig
+	for _, ec := range healthz.elections {
+		if name == "" || ec.Name == name {
+			result = append(result, ec)
 		}
 	}
-	return true
+	return result
+}
+
+type healthStatus struct {
+	Healthy bool          `json:"healthy"`
+	Checks  []checkStatus `json:"checks"`
+}
+
+type checkStatus struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+	Details any    `json:"details,omitempty"`
 }
 
-// startHealthz starts a healthz server on the healthzPort. If the server is already running, then this is a no-op.
-// Similarly, if the healthzPort is <= 0, then this is a no-op.
+type routerStatus struct {
+	Ready bool         `json:"ready"`
+	Kinds []kindStatus `json:"kinds,omitempty"`
+}
+
+type kindStatus struct {
+	Kind   string `json:"kind"`
+	Synced bool   `json:"synced"`
+	Error  string `json:"error,omitempty"`
+}
+
+func checkReady(ctx context.Context) healthStatus {
+	healthz.lock.RLock()
+	ready := make(map[string]bool, len(healthz.ready))
+	for name, r := range healthz.ready {
+		ready[name] = r
+	}
+	routers := make(map[string]*Router, len(healthz.routers))
+	for name, r := range healthz.routers {
+		routers[name] = r
+	}
+	checks := make(map[string]HealthCheck, len(healthz.readyChecks))
+	for name, check := range healthz.readyChecks {
+		checks[name] = check
+	}
+	healthz.lock.RUnlock()
+
+	var result []checkStatus
+	for name, isReady := range ready {
+		status := checkStatus{
+			Name:    "router:" + name,
+			Healthy: isReady,
+		}
+		if !isReady {
+			status.Error = fmt.Sprintf("router %s is not ready", name)
+		}
+		if r, ok := routers[name]; ok {
+			status.Details = routerStatus{
+				Ready: isReady,
+				Kinds: r.handlers.kindStatus(ctx),
+			}
+		}
+		result = append(result, status)
+	}
+
+	return runChecks(ctx, result, checks)
+}
+
+func checkLive(ctx context.Context) healthStatus {
+	healthz.lock.RLock()
+	checks := make(map[string]HealthCheck, len(healthz.liveChecks))
+	for name, check := range healthz.liveChecks {
+		checks[name] = check
+	}
+	routers := make(map[string]*Router, len(healthz.routers))
+	for name, r := range healthz.routers {
+		routers[name] = r
+	}
+	healthz.lock.RUnlock()
+
+	for name, r := range routers {
+		checks["router:"+name] = r.checkLive
+	}
+
+	return runChecks(ctx, nil, checks)
+}
+
+func runChecks(ctx context.Context, result []checkStatus, checks map[string]HealthCheck) healthStatus {
+	for name, check := range checks {
+		status := checkStatus{
+			Name:    name,
+			Healthy: true,
+		}
+		if err := check(ctx); err != nil {
+			status.Healthy = false
+			status.Error = err.Error()
+		}
+		result = append(result, status)
+	}
+
+	slices.SortFunc(result, func(a, b checkStatus) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	healthy := true
+	for _, status := range result {
+		healthy = healthy && status.Healthy
+	}
+	return healthStatus{
+		Healthy: healthy,
+		Checks:  result,
+	}
+}
+
+// serveHealth responds with 200 if all checks are healthy and 503 otherwise. With the verbose query parameter, the
+// result of each check is written as JSON.
+func serveHealth(check func(context.Context) healthStatus) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		status := check(req.Context())
+		code := http.StatusOK
+		if !status.Healthy {
+			code = http.StatusServiceUnavailable
+		}
+
+		if !req.URL.Query().Has("verbose") {
+			w.WriteHeader(code)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		_ = json.NewEncoder(w).Encode(status)
+	}
+}
+
+// leaderStatus is the status of a leader election, or the error getting it.
+type leaderStatus struct {
+	leader.Status
+	Error string `json:"error,omitempty"`
+}
+
+// serveLeaderStatus writes the status of the leader elections of all routers, or of the election given by the name
+// query parameter. Elections whose status cannot be read are reported with an error.
+func serveLeaderStatus(w http.ResponseWriter, req *http.Request) {
+	statuses := []leaderStatus{}
+	for _, ec := range getElections(req.URL.Query().Get("name")) {
+		status, err := ec.Status(req.Context())
+		if err != nil {
+			statuses = append(statuses, leaderStatus{
+				Status: leader.Status{
+					Name:      ec.Name,
+					Namespace: ec.Namespace,
+				},
+				Error: err.Error(),
+			})
+			continue
+		}
+		statuses = append(statuses, leaderStatus{Status: status})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(statuses)
+}
+
+// serveStepDown releases the leases held by this replica for all routers, or for the election given by the name query
+// parameter. Only elections that allow stepping down are considered.
+func serveStepDown(w http.ResponseWriter, req *http.Request) {
+	var elections []*leader.ElectionConfig
+	for _, ec := range getElections(req.URL.Query().Get("name")) {
+		if ec.AllowStepDown {
+			elections = append(elections, ec)
+		}
+	}
+	if len(elections) == 0 {
+		http.Error(w, "stepping down is not allowed", http.StatusForbidden)
+		return
+	}
+
+	steppedDown := []string{}
+	for _, ec := range elections {
+		if ec.StepDown() {
+			log.Infof("Stepping down as leader for %s", ec.Name)
+			steppedDown = append(steppedDown, ec.Name)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string][]string{"steppedDown": steppedDown})
+}
+
+// startHealthz starts a health server on the healthzPort serving /readyz, /livez, and /healthz. If the server is already
+// running, then this is a no-op. Similarly, if the healthzPort is <= 0, then this is a no-op.
 func startHealthz(ctx context.Context) {
 	healthz.lock.Lock()
 	defer healthz.lock.Unlock()
@@ -60,27 +286,21 @@ func startHealthz(ctx context.Context) {
 	}
 	healthz.started = true
 
-	// Catch these signals to ensure a graceful shutdown of the server.
-	sigCtx, cancel := signal.NotifyContext(ctx, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGKILL)
-
 	mux := http.NewServeMux()
-	mux.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
-		if getHealthy() {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-		w.WriteHeader(http.StatusServiceUnavailable)
-	})
+	// healthz is kept for compatibility and is the same as readyz
+	mux.HandleFunc("/healthz", serveHealth(checkReady))
+	mux.HandleFunc("/readyz", serveHealth(checkReady))
+	mux.HandleFunc("/livez", serveHealth(checkLive))
+	mux.HandleFunc("GET /leader", serveLeaderStatus)
+	mux.HandleFunc("POST /leader/step-down", serveStepDown)
 
 	srv := &http.Server{
 		Addr:    fmt.Sprintf(":%d", healthz.port),
 		Handler: mux,
 	}
 	go func() {
-		<-sigCtx.Done()
-		// Must cancel so that the registered signals are no longer caught.
-		cancel()
-		if err := srv.Shutdown(ctx); err != nil {
+		<-ctx.Done()
+		if err := srv.Shutdown(context.WithoutCancel(ctx)); err != nil {
 			log.Warnf("error shutting down healthz server: %v", err)
 		}
 	}()
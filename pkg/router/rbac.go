@@ -0,0 +1,55 @@
+package router
+
+import (
+	"sort"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+// PolicyRules returns the RBAC rules the router needs to watch and update the types it handles and their status, and
+// to hold its leases if it uses leader election or sharding. Resource names are guessed from the kinds, as no cluster
+// is needed to generate them.
+//
+// The rules do not cover the objects the handlers apply, or any other types they read or write. Those must be granted
+// separately, for example with kubebuilder:rbac markers.
+func (r *Router) PolicyRules() []rbacv1.PolicyRule {
+	resources := map[string][]string{}
+	for _, gvk := range r.handlers.handlers.GVKs() {
+		gvr, _ := meta.UnsafeGuessKindToResource(gvk)
+		resources[gvr.Group] = append(resources[gvr.Group], gvr.Resource)
+	}
+
+	groups := make([]string, 0, len(resources))
+	for group := range resources {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	var result []rbacv1.PolicyRule
+	for _, group := range groups {
+		sort.Strings(resources[group])
+		status := make([]string, 0, len(resources[group]))
+		for _, resource := range resources[group] {
+			status = append(status, resource+"/status")
+		}
+		result = append(result, rbacv1.PolicyRule{
+			APIGroups: []string{group},
+			Resources: resources[group],
+			Verbs:     []string{"get", "list", "watch", "update", "patch"},
+		}, rbacv1.PolicyRule{
+			APIGroups: []string{group},
+			Resources: status,
+			Verbs:     []string{"get", "update", "patch"},
+		})
+	}
+	if r.electionConfig != nil || r.shardConfig != nil {
+		result = append(result, rbacv1.PolicyRule{
+			APIGroups: []string{coordinationv1.GroupName},
+			Resources: []string{"leases"},
+			Verbs:     []string{"get", "list", "watch", "create", "update", "delete"},
+		})
+	}
+	return result
+}
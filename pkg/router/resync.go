@@ -0,0 +1,131 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/acorn-io/baaah/pkg/backend"
+	"github.com/acorn-io/baaah/pkg/log"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// resyncJitter is the maximum fraction of the period added to each resync so that routes with the same period do
+// not all enqueue their objects at once.
+const resyncJitter = 0.2
+
+type resync struct {
+	gvk           schema.GroupVersionKind
+	period        time.Duration
+	namespace     string
+	name          string
+	sel           labels.Selector
+	fieldSelector fields.Selector
+}
+
+func (r resync) matches(obj kclient.Object) bool {
+	if r.name != "" && obj.GetName() != r.name {
+		return false
+	}
+	if r.sel != nil && !r.sel.Matches(labels.Set(obj.GetLabels())) {
+		return false
+	}
+	if r.fieldSelector != nil {
+		f, ok := obj.(fields.Fields)
+		if !ok || !r.fieldSelector.Matches(f) {
+			return false
+		}
+	}
+	return true
+}
+
+// addResync periodically enqueues all cached objects of the type that match the filters of the resync. If the
+// HandlerSet is already started, the resync starts immediately.
+func (m *HandlerSet) addResync(objType kclient.Object, r resync) {
+	gvk, err := m.backend.GVKForObject(objType, m.scheme)
+	if err != nil {
+		panic(fmt.Sprintf("scheme does not know gvk for %T", objType))
+	}
+	r.gvk = gvk
+
+	m.resyncLock.Lock()
+	defer m.resyncLock.Unlock()
+	m.resyncs = append(m.resyncs, r)
+	if m.resyncCtx != nil {
+		go m.runResync(m.resyncCtx, r)
+	}
+}
+
+func (m *HandlerSet) startResyncs(ctx context.Context) {
+	m.resyncLock.Lock()
+	defer m.resyncLock.Unlock()
+	m.resyncCtx = ctx
+	for _, r := range m.resyncs {
+		go m.runResync(ctx, r)
+	}
+}
+
+func (m *HandlerSet) runResync(ctx context.Context, r resync) {
+	for {
+		timer := time.NewTimer(wait.Jitter(r.period, resyncJitter))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if err := m.resync(ctx, r); err != nil {
+			log.Errorf("failed to resync %v: %v", r.gvk, err)
+		}
+	}
+}
+
+func (m *HandlerSet) resync(ctx context.Context, r resync) error {
+	count, err := m.enqueueAll(ctx, r.gvk, r.namespace, r.matches)
+	log.Debugf("Resynced %d objects of %v", count, r.gvk)
+	return err
+}
+
+// enqueueAll enqueues the keys of all cached objects of the kind in the namespace that match.
+func (m *HandlerSet) enqueueAll(ctx context.Context, gvk schema.GroupVersionKind, namespace string, match func(kclient.Object) bool) (int, error) {
+	list, err := m.scheme.New(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+	if err != nil {
+		return 0, err
+	}
+
+	if err := m.backend.List(ctx, list.(kclient.ObjectList), kclient.InNamespace(namespace)); err != nil {
+		return 0, err
+	}
+
+	var count int
+	err = meta.EachListItem(list, func(obj runtime.Object) error {
+		mObj := obj.(kclient.Object)
+		if !match(mObj) {
+			return nil
+		}
+		count++
+		return m.enqueueResync(gvk, keyFunc(mObj.GetNamespace(), mObj.GetName()))
+	})
+	return count, err
+}
+
+func (m *HandlerSet) enqueueResync(gvk schema.GroupVersionKind, key string) error {
+	if r, ok := m.backend.(backend.Resyncer); ok {
+		return r.Resync(gvk, key)
+	}
+	return m.backend.Trigger(gvk, ReplayPrefix+key, 0)
+}
+
+func keyFunc(namespace, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + "/" + name
+}
@@ -2,13 +2,19 @@ package router
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/acorn-io/baaah/pkg/backend"
 	"github.com/acorn-io/baaah/pkg/leader"
+	"github.com/acorn-io/baaah/pkg/log"
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/labels"
 	kclient "sigs.k8s.io/controller-runtime/pkg/client"
@@ -18,20 +24,36 @@ type Router struct {
 	RouteBuilder
 
 	OnErrorHandler ErrorHandler
+	name           string
 	handlers       *HandlerSet
 	electionConfig *leader.ElectionConfig
+	shardConfig    *leader.ShardConfig
 	hasHealthz     bool
+
+	lock      sync.Mutex
+	started   bool
+	stop      context.CancelFunc
+	ready     chan struct{}
+	readyOnce sync.Once
+	done      chan struct{}
+	doneOnce  sync.Once
+	err       error
 }
 
 // New returns a new *Router with given HandlerSet and ElectionConfig. Passing a nil ElectionConfig is valid and results
 // in no leader election for the router.
 // The healthzPort is the port on which the healthz endpoint will be served. If <= 0, the healthz endpoint will not be
 // served. When creating multiple routers, the first router created with a positive healthzPort will be used.
-// The healthz endpoint is served on /healthz, and will not be started until the router is started.
+// The healthz endpoint is served on /healthz, and will not be started until the router is started. The same server
+// serves the status of the leader election on /leader, and a POST to /leader/step-down makes this replica release the
+// lease so that another replica takes over.
 func New(handlerSet *HandlerSet, electionConfig *leader.ElectionConfig, healthzPort int) *Router {
 	r := &Router{
+		name:           handlerSet.name,
 		handlers:       handlerSet,
 		electionConfig: electionConfig,
+		ready:          make(chan struct{}),
+		done:           make(chan struct{}),
 	}
 
 	if healthzPort > 0 {
@@ -43,10 +65,29 @@ func New(handlerSet *HandlerSet, electionConfig *leader.ElectionConfig, healthzP
 	return r
 }
 
+// SetShardConfig spreads the keys of this router across replicas instead of using leader election. Every replica
+// watches all objects but only handles the keys in the shards it holds. This must be called before Start.
+func (r *Router) SetShardConfig(shardConfig *leader.ShardConfig) {
+	r.shardConfig = shardConfig
+}
+
 func (r *Router) Backend() backend.Backend {
 	return r.handlers.backend
 }
 
+// SetWorkers changes the number of workers processing objects of the given type without restarting the router.
+func (r *Router) SetWorkers(objType kclient.Object, workers int) error {
+	ws, ok := r.handlers.backend.(backend.WorkerSetter)
+	if !ok {
+		return fmt.Errorf("backend %T does not support setting workers", r.handlers.backend)
+	}
+	gvk, err := r.handlers.backend.GVKForObject(objType, r.handlers.scheme)
+	if err != nil {
+		return err
+	}
+	return ws.SetWorkers(gvk, workers)
+}
+
 type RouteBuilder struct {
 	includeRemove     bool
 	includeFinalizing bool
@@ -59,6 +100,8 @@ type RouteBuilder struct {
 	middleware        []Middleware
 	sel               labels.Selector
 	fieldSelector     fields.Selector
+	resyncPeriod      time.Duration
+	timeout           time.Duration
 }
 
 func (r RouteBuilder) Middleware(m ...Middleware) RouteBuilder {
@@ -81,6 +124,21 @@ func (r RouteBuilder) FieldSelector(sel fields.Selector) RouteBuilder {
 	return r
 }
 
+// ResyncEvery periodically re-enqueues all cached objects of the type that match the namespace, name, and selectors of
+// the route. This is useful for handlers that reconcile against state outside the cluster. Each period is extended by
+// a random jitter of up to 20%.
+func (r RouteBuilder) ResyncEvery(period time.Duration) RouteBuilder {
+	r.resyncPeriod = period
+	return r
+}
+
+// Timeout sets the deadline of the context passed to each invocation of the handler. This overrides the default handler
+// timeout of the router. A handler that times out is retried with backoff.
+func (r RouteBuilder) Timeout(d time.Duration) RouteBuilder {
+	r.timeout = d
+	return r
+}
+
 func (r RouteBuilder) Name(name string) RouteBuilder {
 	r.name = name
 	return r
@@ -137,6 +195,16 @@ func (r RouteBuilder) Handler(h Handler) {
 	for i := len(r.middleware) - 1; i >= 0; i-- {
 		result = r.middleware[i](result)
 	}
+	timeout := r.timeout
+	if timeout == 0 {
+		timeout = r.router.handlers.handlerTimeout
+	}
+	if timeout > 0 {
+		result = TimeoutHandler{
+			Timeout: timeout,
+			Next:    result,
+		}
+	}
 	if r.name != "" || r.namespace != "" {
 		result = NameNamespaceFilter{
 			Next:      result,
@@ -175,26 +243,170 @@ func (r RouteBuilder) Handler(h Handler) {
 	}
 
 	r.router.handlers.AddHandler(r.objType, result)
+
+	if r.resyncPeriod > 0 {
+		r.router.handlers.addResync(r.objType, resync{
+			period:        r.resyncPeriod,
+			namespace:     r.namespace,
+			name:          r.name,
+			sel:           r.sel,
+			fieldSelector: r.fieldSelector,
+		})
+	}
 }
 
+// Start starts the router. When ctx is canceled or the process receives SIGTERM or SIGQUIT, the router stops processing
+// new keys, waits for the handlers that are running to finish, and then releases the leader election lease. Handlers
+// are passed a context that remains valid until they are drained. Use Ready and Done to know when the router is running
+// and when it has stopped.
+//
+// The process is not exited when the router stops. Since the signals are caught, main is expected to wait for the
+// router with Wait or Done and then return. Set IgnoreSignals on the election config to leave the signals to the
+// process instead.
 func (r *Router) Start(ctx context.Context) error {
+	r.lock.Lock()
+	if r.started {
+		r.lock.Unlock()
+		return fmt.Errorf("router %s has already been started", r.handlers.name)
+	}
+	select {
+	case <-r.done:
+		r.lock.Unlock()
+		return fmt.Errorf("router %s has been stopped", r.handlers.name)
+	default:
+	}
+	r.started = true
+	stopCtx, stop := context.WithCancel(ctx)
+	r.stop = stop
+	r.lock.Unlock()
+
 	id, err := os.Hostname()
 	if err != nil {
+		stop()
+		r.finish(err)
 		return err
 	}
 
 	if r.hasHealthz {
+		if r.electionConfig != nil && r.shardConfig == nil {
+			addElection(r.electionConfig)
+		}
+		addRouter(r)
 		startHealthz(ctx)
 	}
 
 	r.handlers.onError = r.OnErrorHandler
 
-	// It's OK to start the electionConfig even if it's nil.
-	return r.electionConfig.Run(ctx, id, r.startHandlers, func(leader string) {
-		// I am not the leader, so I am healthy until my controllers are started.
-		if r.hasHealthz {
-			setHealthy(r.name, id != leader)
+	sigCtx, stopSignals := stopCtx, func() {}
+	if r.electionConfig == nil || !r.electionConfig.IgnoreSignals {
+		// Catch these signals to ensure a graceful shutdown and leader election release.
+		sigCtx, stopSignals = signal.NotifyContext(stopCtx, syscall.SIGTERM, syscall.SIGQUIT)
+	}
+	runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+
+	// The cache lives as long as the router, so that the handlers can be stopped and started again when leadership is
+	// lost and regained.
+	if cs, ok := r.handlers.backend.(backend.CacheStarter); ok {
+		cs.StartCache(runCtx)
+	}
+
+	var electionDone <-chan struct{}
+	if r.shardConfig != nil {
+		electionDone, err = r.startSharded(runCtx, id)
+	} else {
+		// It's OK to start the electionConfig even if it's nil.
+		electionDone, err = r.electionConfig.Start(runCtx, id, r.startHandlers, func(leader string) {
+			// I am not the leader, so I am healthy until my controllers are started.
+			if r.hasHealthz {
+				setReady(r.name, id != leader)
+			}
+		})
+	}
+	if err != nil {
+		stopSignals()
+		cancel()
+		stop()
+		r.finish(err)
+		return err
+	}
+
+	go func() {
+		<-sigCtx.Done()
+		// Must stop so that the registered signals are no longer caught.
+		stopSignals()
+
+		log.Infof("Shutting down router %s", r.handlers.name)
+		err := r.handlers.Shutdown(runCtx)
+		if err != nil {
+			log.Errorf("failed to drain handlers of router %s: %v", r.handlers.name, err)
+		}
+		// Canceling the handlers' context releases the leader election lease.
+		cancel()
+		<-electionDone
+		log.Infof("Router %s stopped", r.handlers.name)
+		r.finish(err)
+	}()
+
+	return nil
+}
+
+// Ready returns a channel that is closed once this router's handlers are running and their caches are synced. With
+// leader election, this only happens after this router becomes the leader.
+func (r *Router) Ready() <-chan struct{} {
+	return r.ready
+}
+
+// Done returns a channel that is closed once the router has stopped: its handlers are drained and the leader election
+// lease is released.
+func (r *Router) Done() <-chan struct{} {
+	return r.done
+}
+
+// Wait blocks until the router has stopped and returns the error, if any, that stopped it or that occurred while
+// draining its handlers.
+func (r *Router) Wait() error {
+	<-r.done
+	return r.err
+}
+
+// Stop stops the router as if the context passed to Start was canceled, and waits until the router has stopped or ctx
+// is done. Stopping a router that was never started prevents it from being started.
+func (r *Router) Stop(ctx context.Context) error {
+	r.lock.Lock()
+	if !r.started {
+		r.finish(nil)
+		r.lock.Unlock()
+		return nil
+	}
+	stop := r.stop
+	r.lock.Unlock()
+
+	stop()
+
+	select {
+	case <-r.done:
+		return r.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// checkLive fails if the router stopped because of an error or if all workers of a kind are stuck.
+func (r *Router) checkLive(context.Context) error {
+	select {
+	case <-r.done:
+		if r.err != nil {
+			return fmt.Errorf("router %s stopped: %w", r.name, r.err)
 		}
+	default:
+	}
+	return r.handlers.checkStuck()
+}
+
+func (r *Router) finish(err error) {
+	r.doneOnce.Do(func() {
+		r.err = err
+		close(r.done)
 	})
 }
 
@@ -203,11 +415,24 @@ func (r *Router) startHandlers(ctx context.Context) error {
 	var err error
 	// This is the leader now, so not ready until the controller is started and caches are ready.
 	if r.hasHealthz {
-		setHealthy(r.name, false)
-		defer setHealthy(r.name, err == nil)
+		setReady(r.name, false)
+		defer func() {
+			setReady(r.name, err == nil)
+		}()
 	}
 
 	err = r.handlers.Start(ctx)
+	if err == nil && r.hasHealthz {
+		context.AfterFunc(ctx, func() {
+			// Leadership was lost, so this router is not ready until it is the leader again or another leader is elected.
+			setReady(r.name, false)
+		})
+	}
+	if err == nil {
+		r.readyOnce.Do(func() {
+			close(r.ready)
+		})
+	}
 
 	return err
 }
@@ -273,6 +498,41 @@ func (e ErrorPrefix) Handle(req Request, resp Response) error {
 	}
 }
 
+// TimeoutHandler invokes the next handler with a context that is canceled after the timeout. If the handler fails
+// after the timeout, the error wraps context.DeadlineExceeded.
+type TimeoutHandler struct {
+	Timeout time.Duration
+	Next    Handler
+}
+
+type timeoutError struct {
+	Timeout time.Duration
+	Err     error
+}
+
+func (t timeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s: %v", t.Timeout, t.Err)
+}
+
+func (t timeoutError) Unwrap() []error {
+	return []error{context.DeadlineExceeded, t.Err}
+}
+
+func (t TimeoutHandler) Handle(req Request, resp Response) error {
+	ctx, cancel := context.WithTimeout(req.Ctx, t.Timeout)
+	defer cancel()
+
+	req.Ctx = ctx
+	err := t.Next.Handle(req, resp)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) && !errors.Is(err, context.DeadlineExceeded) {
+		return timeoutError{
+			Timeout: t.Timeout,
+			Err:     err,
+		}
+	}
+	return err
+}
+
 type NameNamespaceFilter struct {
 	Next      Handler
 	Name      string
@@ -0,0 +1,166 @@
+package router
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/acorn-io/baaah/pkg/log"
+	"golang.org/x/exp/maps"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// sharding tracks the shards held by this replica. A nil *sharding owns every key.
+type sharding struct {
+	lock     sync.RWMutex
+	shardFor func(namespace, name string) int
+	owned    map[int]bool
+	// handling is the number of keys being handled in each shard
+	handling map[int]int
+	// idle is closed and replaced each time a key is done being handled
+	idle chan struct{}
+}
+
+func (s *sharding) shardOf(key string) int {
+	ns, name, ok := strings.Cut(key, "/")
+	if !ok {
+		name = ns
+		ns = ""
+	}
+	return s.shardFor(ns, name)
+}
+
+func (s *sharding) owns(key string) bool {
+	if s == nil {
+		return true
+	}
+	shard := s.shardOf(key)
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.owned[shard]
+}
+
+// begin returns false if the key is not in an owned shard. Otherwise, done must be called once the key is handled.
+func (s *sharding) begin(key string) (done func(), ok bool) {
+	if s == nil {
+		return func() {}, true
+	}
+	shard := s.shardOf(key)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.owned[shard] {
+		return nil, false
+	}
+	s.handling[shard]++
+
+	return func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.handling[shard]--
+		close(s.idle)
+		s.idle = make(chan struct{})
+	}, true
+}
+
+// drain waits until no keys of the shards are being handled or the context is done.
+func (s *sharding) drain(ctx context.Context, shards map[int]bool) error {
+	for {
+		s.lock.RLock()
+		busy := false
+		for shard := range shards {
+			if s.handling[shard] > 0 {
+				busy = true
+				break
+			}
+		}
+		idle := s.idle
+		s.lock.RUnlock()
+
+		if !busy {
+			return nil
+		}
+		select {
+		case <-idle:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// EnableSharding makes the HandlerSet only handle keys in the shards passed to SetOwnedShards. Changes to objects
+// in other shards still invoke the triggers registered by keys in owned shards. This must be called before Start.
+func (m *HandlerSet) EnableSharding(shardFor func(namespace, name string) int) {
+	m.sharding = &sharding{
+		shardFor: shardFor,
+		owned:    map[int]bool{},
+		handling: map[int]int{},
+		idle:     make(chan struct{}),
+	}
+	m.triggers.owns = m.sharding.owns
+}
+
+// SetOwnedShards sets the shards handled by this HandlerSet. All cached objects in newly owned shards are enqueued.
+// For shards that are no longer owned, it waits until the keys being handled are done or the context is done, so that
+// the shards can be released safely afterwards.
+func (m *HandlerSet) SetOwnedShards(ctx context.Context, shards []int) {
+	if m.sharding == nil {
+		return
+	}
+
+	owned := map[int]bool{}
+	gained := map[int]bool{}
+	lost := map[int]bool{}
+	for _, shard := range shards {
+		owned[shard] = true
+	}
+
+	m.sharding.lock.Lock()
+	for shard := range owned {
+		if !m.sharding.owned[shard] {
+			gained[shard] = true
+		}
+	}
+	for shard := range m.sharding.owned {
+		if !owned[shard] {
+			lost[shard] = true
+		}
+	}
+	m.sharding.owned = owned
+	m.sharding.lock.Unlock()
+
+	if len(lost) > 0 {
+		if err := m.sharding.drain(ctx, lost); err != nil {
+			log.Errorf("failed to wait for keys of released shards to be handled: %v", err)
+		}
+	}
+
+	if len(gained) == 0 || m.ctx == nil {
+		return
+	}
+
+	m.watchingLock.Lock()
+	gvks := maps.Keys(m.watching)
+	m.watchingLock.Unlock()
+
+	for _, gvk := range gvks {
+		count, err := m.enqueueAll(m.ctx, gvk, "", func(obj kclient.Object) bool {
+			return gained[m.sharding.shardFor(obj.GetNamespace(), obj.GetName())]
+		})
+		if err != nil {
+			log.Errorf("failed to enqueue objects of %v in acquired shards: %v", gvk, err)
+		}
+		log.Debugf("Enqueued %d objects of %v in acquired shards", count, gvk)
+	}
+}
+
+// startSharded starts the handlers immediately instead of waiting for leadership, because every replica watches all
+// objects and only the handling of keys is sharded.
+func (r *Router) startSharded(ctx context.Context, id string) (<-chan struct{}, error) {
+	r.handlers.EnableSharding(r.shardConfig.ShardFor)
+	if err := r.startHandlers(ctx); err != nil {
+		return nil, err
+	}
+	return r.shardConfig.Start(ctx, id, r.handlers.SetOwnedShards)
+}
@@ -4,7 +4,6 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -32,26 +31,6 @@ type Harness struct {
 	ExpectedDelay      time.Duration
 }
 
-func genericToTyped(scheme *runtime.Scheme, objs []runtime.Object) ([]kclient.Object, error) {
-	result := make([]kclient.Object, 0, len(objs))
-	for _, obj := range objs {
-		typedObj, err := scheme.New(obj.GetObjectKind().GroupVersionKind())
-		if err != nil {
-			return nil, err
-		}
-
-		bytes, err := json.Marshal(obj)
-		if err != nil {
-			return nil, err
-		}
-		if err := json.Unmarshal(bytes, typedObj); err != nil {
-			return nil, err
-		}
-		result = append(result, typedObj.(kclient.Object))
-	}
-	return result, nil
-}
-
 func readFile(scheme *runtime.Scheme, dir, file string) ([]kclient.Object, error) {
 	var (
 		path = filepath.Join(dir, file)
@@ -85,7 +64,7 @@ func readFile(scheme *runtime.Scheme, dir, file string) ([]kclient.Object, error
 	if err != nil {
 		return nil, fmt.Errorf("unmarshalling %s: %w", path, err)
 	}
-	typedObjects, err := genericToTyped(scheme, newObjects)
+	typedObjects, err := yaml.ToTypedObjects(scheme, newObjects)
 	if err != nil {
 		return nil, err
 	}
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/acorn-io/baaah/pkg/metadata"
 	"github.com/acorn-io/baaah/pkg/router"
 	"github.com/acorn-io/baaah/pkg/uncached"
 	"github.com/google/uuid"
@@ -36,6 +37,9 @@ func (c *Client) Get(ctx context.Context, key kclient.ObjectKey, out kclient.Obj
 	if u, ok := out.(*uncached.Holder); ok {
 		out = u.Object
 	}
+	if u, ok := out.(*metadata.Holder); ok {
+		out = u.Object
+	}
 	t := reflect.TypeOf(out)
 	var ns string
 	if key.Namespace != "" {
@@ -71,6 +75,9 @@ func (c *Client) List(ctx context.Context, objList kclient.ObjectList, opts ...k
 	if u, ok := objList.(*uncached.HolderList); ok {
 		objList = u.ObjectList
 	}
+	if u, ok := objList.(*metadata.HolderList); ok {
+		objList = u.ObjectList
+	}
 
 	listOpts := &kclient.ListOptions{}
 	for _, opt := range opts {
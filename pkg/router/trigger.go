@@ -6,6 +6,7 @@ import (
 
 	"github.com/acorn-io/baaah/pkg/backend"
 	"github.com/acorn-io/baaah/pkg/log"
+	"github.com/acorn-io/baaah/pkg/metadata"
 	"github.com/acorn-io/baaah/pkg/uncached"
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/labels"
@@ -21,10 +22,14 @@ type triggers struct {
 	gvkLookup backend.Backend
 	scheme    *runtime.Scheme
 	watcher   watcher
+	// owns returns false for keys handled by another replica, which are not triggered. If nil, all keys are triggered.
+	owns func(key string) bool
 }
 
 type watcher interface {
 	WatchGVK(gvks ...schema.GroupVersionKind) error
+	WatchMetadataGVK(gvks ...schema.GroupVersionKind) error
+	Unwatch(gvks ...schema.GroupVersionKind)
 }
 
 type enqueueTarget struct {
@@ -41,6 +46,9 @@ func (m *triggers) invokeTriggers(req Request) {
 			enqueueTarget.key == req.Key {
 			continue
 		}
+		if m.owns != nil && !m.owns(enqueueTarget.key) {
+			continue
+		}
 		for _, matcher := range matchers {
 			if matcher.Match(req.Namespace, req.Name, req.Object) {
 				log.Debugf("Triggering [%s] [%v] from [%s] [%v]", enqueueTarget.key, enqueueTarget.gvk, req.Key, req.GVK)
@@ -72,6 +80,13 @@ func (m *triggers) register(gvk schema.GroupVersionKind, key string, targetGVK s
 	matchers[target] = append(matchers[target], mr)
 }
 
+// IsWatched returns true if any trigger is registered for objects of the given kind.
+func (m *triggers) IsWatched(gvk schema.GroupVersionKind) bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return len(m.matchers[gvk]) > 0
+}
+
 func (m *triggers) Trigger(req Request) {
 	if !req.FromTrigger {
 		m.invokeTriggers(req)
@@ -98,12 +113,22 @@ func (m *triggers) Register(sourceGVK schema.GroupVersionKind, key string, obj r
 		Fields:    fields,
 	})
 
+	if metadata.IsMetadataOnly(obj) {
+		return gvk, true, m.watcher.WatchMetadataGVK(gvk)
+	}
 	return gvk, true, m.watcher.WatchGVK(gvk)
 }
 
 // UnregisterAndTrigger will unregister all triggers for the object, both as source and target.
 // If a trigger source matches the object exactly, then the trigger will be invoked.
 func (m *triggers) UnregisterAndTrigger(req Request) {
+	if unused := m.unregisterAndTrigger(req); len(unused) > 0 {
+		m.watcher.Unwatch(unused...)
+	}
+}
+
+// unregisterAndTrigger returns the kinds that no longer have any triggers registered.
+func (m *triggers) unregisterAndTrigger(req Request) (unused []schema.GroupVersionKind) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
@@ -123,7 +148,7 @@ func (m *triggers) UnregisterAndTrigger(req Request) {
 					}
 					remainingMatchers[targetGVK][target] = append(remainingMatchers[targetGVK][target], mt)
 				}
-				if targetGVK == req.GVK && mt.Match(req.Namespace, req.Name, req.Object) {
+				if targetGVK == req.GVK && mt.Match(req.Namespace, req.Name, req.Object) && (m.owns == nil || m.owns(target.key)) {
 					log.Debugf("Triggering [%s] [%v] from [%s] [%v] on delete", target.key, target.gvk, req.Key, req.GVK)
 					_ = m.trigger.Trigger(target.gvk, target.key, 0)
 				}
@@ -131,5 +156,12 @@ func (m *triggers) UnregisterAndTrigger(req Request) {
 		}
 	}
 
+	for targetGVK := range m.matchers {
+		if len(remainingMatchers[targetGVK]) == 0 {
+			unused = append(unused, targetGVK)
+		}
+	}
+
 	m.matchers = remainingMatchers
+	return unused
 }
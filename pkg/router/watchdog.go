@@ -0,0 +1,227 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/acorn-io/baaah/pkg/backend"
+	"github.com/acorn-io/baaah/pkg/log"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// DefaultReconcileTimeout is the deadline of the context passed to handlers for each reconcile of a key.
+	DefaultReconcileTimeout = 5 * time.Minute
+	// DefaultStuckThreshold is how long a reconcile may run before it is reported as stuck.
+	DefaultStuckThreshold = 10 * time.Minute
+)
+
+// ErrObjectDeleted is the cause of the cancellation of the context of a reconcile whose object was deleted while it
+// was running.
+var ErrObjectDeleted = errors.New("object was deleted")
+
+type reconcile struct {
+	gvk       schema.GroupVersionKind
+	key       string
+	goroutine string
+	started   time.Time
+	reported  bool
+	cancel    context.CancelCauseFunc
+}
+
+type watchdog struct {
+	lock      sync.Mutex
+	next      uint64
+	running   map[uint64]*reconcile
+	threshold time.Duration
+}
+
+// SetReconcileTimeout sets the deadline of the context passed to handlers for each reconcile of a key. Zero or less
+// disables the deadline.
+func (m *HandlerSet) SetReconcileTimeout(d time.Duration) {
+	m.reconcileTimeout = d
+}
+
+// SetHandlerTimeout sets the deadline of the context passed to each invocation of a handler that does not set its own
+// with RouteBuilder.Timeout. This only applies to handlers added afterward. Zero or less disables the deadline.
+func (m *HandlerSet) SetHandlerTimeout(d time.Duration) {
+	m.handlerTimeout = d
+}
+
+// SetStuckThreshold sets how long a reconcile may run before it is logged with its goroutine stack. If all workers of
+// a kind are running reconciles longer than this, the router is no longer live. Zero or less disables the watchdog.
+func (m *HandlerSet) SetStuckThreshold(d time.Duration) {
+	m.watchdog.threshold = d
+}
+
+// startReconcile returns the context for a reconcile of the key and a func that must be called when it is done. The
+// context is canceled with ErrObjectDeleted if the object is deleted before the reconcile is done.
+func (m *HandlerSet) startReconcile(gvk schema.GroupVersionKind, key string) (context.Context, func()) {
+	ctx, cancelCause := context.WithCancelCause(m.ctx)
+	cancel := context.CancelFunc(func() {})
+	if m.reconcileTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, m.reconcileTimeout)
+	}
+
+	w := &m.watchdog
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.running == nil {
+		w.running = map[uint64]*reconcile{}
+	}
+	id := w.next
+	w.next++
+	w.running[id] = &reconcile{
+		gvk:       gvk,
+		key:       key,
+		goroutine: goroutineID(),
+		started:   time.Now(),
+		cancel:    cancelCause,
+	}
+
+	return ctx, func() {
+		cancel()
+		cancelCause(nil)
+		w.lock.Lock()
+		defer w.lock.Unlock()
+		if r := w.running[id]; r != nil && r.reported {
+			log.Infof("Reconcile of [%s] [%v] finished after %s", r.key, r.gvk, time.Since(r.started).Round(time.Second))
+		}
+		delete(w.running, id)
+	}
+}
+
+// cancelOnDelete cancels the running reconciles of objects of the kind that are deleted until ctx is done.
+func (m *HandlerSet) cancelOnDelete(ctx context.Context, gvk schema.GroupVersionKind) {
+	dn, ok := m.backend.(backend.DeleteNotifier)
+	if !ok {
+		return
+	}
+	err := dn.OnDelete(ctx, gvk, func(key string) {
+		m.watchdog.lock.Lock()
+		defer m.watchdog.lock.Unlock()
+		for _, r := range m.watchdog.running {
+			if r.gvk == gvk && r.key == key {
+				log.Debugf("Canceling reconcile of [%s] [%v], the object was deleted", key, gvk)
+				r.cancel(ErrObjectDeleted)
+			}
+		}
+	})
+	if err != nil {
+		log.Errorf("failed to watch deletes of %v: %v", gvk, err)
+	}
+}
+
+func (m *HandlerSet) startWatchdog(ctx context.Context) {
+	if m.watchdog.threshold <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(m.watchdog.threshold / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.watchdog.report()
+			}
+		}
+	}()
+}
+
+// report logs the reconciles that have been running longer than the threshold with their goroutine stacks. Each
+// reconcile is only reported once.
+func (w *watchdog) report() {
+	var stuck []reconcile
+	w.lock.Lock()
+	for _, r := range w.running {
+		if !r.reported && time.Since(r.started) > w.threshold {
+			r.reported = true
+			stuck = append(stuck, *r)
+		}
+	}
+	w.lock.Unlock()
+
+	if len(stuck) == 0 {
+		return
+	}
+
+	stacks := goroutineStacks()
+	for _, r := range stuck {
+		log.Errorf("Reconcile of [%s] [%v] has been running for %s:\n%s", r.key, r.gvk,
+			time.Since(r.started).Round(time.Second), stacks[r.goroutine])
+	}
+}
+
+// checkStuck returns an error if all workers of a kind are running reconciles longer than the threshold.
+func (m *HandlerSet) checkStuck() error {
+	wc, ok := m.backend.(backend.WorkerCounter)
+	if !ok || m.watchdog.threshold <= 0 {
+		return nil
+	}
+
+	stuck := map[schema.GroupVersionKind]int{}
+	m.watchdog.lock.Lock()
+	for _, r := range m.watchdog.running {
+		if time.Since(r.started) > m.watchdog.threshold {
+			stuck[r.gvk]++
+		}
+	}
+	m.watchdog.lock.Unlock()
+
+	var stuckKinds []string
+	for gvk, count := range stuck {
+		if count >= wc.Workers(gvk) {
+			stuckKinds = append(stuckKinds, gvk.String())
+		}
+	}
+	if len(stuckKinds) > 0 {
+		return fmt.Errorf("all workers are stuck for %s", strings.Join(stuckKinds, ", "))
+	}
+	return nil
+}
+
+// goroutineID returns the ID of the current goroutine as found in its stack trace.
+func goroutineID() string {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	// The stack starts with "goroutine 123 [running]:"
+	fields := bytes.Fields(buf)
+	if len(fields) < 2 {
+		return ""
+	}
+	if _, err := strconv.ParseUint(string(fields[1]), 10, 64); err != nil {
+		return ""
+	}
+	return string(fields[1])
+}
+
+// goroutineStacks returns the stack of every goroutine keyed by its ID.
+func goroutineStacks() map[string]string {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	result := map[string]string{}
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		fields := strings.Fields(stack)
+		if len(fields) > 1 && fields[0] == "goroutine" {
+			result[fields[1]] = stack
+		}
+	}
+	return result
+}
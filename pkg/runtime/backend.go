@@ -11,6 +11,7 @@ import (
 
 	"github.com/acorn-io/baaah/pkg/backend"
 	"github.com/acorn-io/baaah/pkg/fields"
+	"github.com/acorn-io/baaah/pkg/metadata"
 	"github.com/acorn-io/baaah/pkg/router"
 	"github.com/acorn-io/baaah/pkg/uncached"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -21,6 +22,8 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 )
 
+// DefaultThreadiness is the number of workers for each kind when not otherwise configured. It can be set with the
+// BAAAH_THREADINESS environment variable.
 var DefaultThreadiness = 5
 
 func init() {
@@ -37,9 +40,13 @@ type Backend struct {
 	cache        cache.Cache
 	startedLock  *sync.RWMutex
 	started      bool
+	ctx          context.Context
 }
 
 func newBackend(cacheFactory SharedControllerFactory, client *cacheClient, cache cache.Cache) *Backend {
+	if l, ok := cacheFactory.(informerLookup); ok {
+		client.writes.informers = l
+	}
 	return &Backend{
 		cacheClient:  client,
 		cacheFactory: cacheFactory,
@@ -56,21 +63,31 @@ func (b *Backend) Start(ctx context.Context) (err error) {
 			b.started = true
 		}
 	}()
-	if err := b.cacheFactory.Start(ctx, DefaultThreadiness); err != nil {
+	// Zero workers uses the defaults configured on the factory.
+	if err := b.cacheFactory.Start(ctx, 0); err != nil {
 		return err
 	}
 	if !b.cache.WaitForCacheSync(ctx) {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
-	if !b.started {
+	// After losing leadership, the backend is started again with a new context.
+	if !b.started || b.ctx.Err() != nil {
+		b.ctx = ctx
 		b.cacheClient.startPurge(ctx)
 	}
 
 	return nil
 }
 
+func (b *Backend) controllerFor(gvk schema.GroupVersionKind) (SharedController, error) {
+	if b.metadataOnly[gvk] {
+		return b.cacheFactory.ForMetadataKind(gvk)
+	}
+	return b.cacheFactory.ForKind(gvk)
+}
+
 func (b *Backend) Trigger(gvk schema.GroupVersionKind, key string, delay time.Duration) error {
-	controller, err := b.cacheFactory.ForKind(gvk)
+	controller, err := b.controllerFor(gvk)
 	if err != nil {
 		return err
 	}
@@ -87,6 +104,17 @@ func (b *Backend) Trigger(gvk schema.GroupVersionKind, key string, delay time.Du
 	return nil
 }
 
+// Resync enqueues the key with a low priority so that it is processed after changes observed by the informer and
+// triggers.
+func (b *Backend) Resync(gvk schema.GroupVersionKind, key string) error {
+	controller, err := b.controllerFor(gvk)
+	if err != nil {
+		return err
+	}
+	controller.EnqueueKeyWithPriority(key, PriorityLow)
+	return nil
+}
+
 func (b *Backend) addIndexer(ctx context.Context, gvk schema.GroupVersionKind) error {
 	obj, err := b.Scheme().New(gvk)
 	if err != nil {
@@ -125,6 +153,9 @@ func (b *Backend) addIndexer(ctx context.Context, gvk schema.GroupVersionKind) e
 }
 
 func (b *Backend) Watcher(ctx context.Context, gvk schema.GroupVersionKind, name string, cb backend.Callback) error {
+	if b.metadataOnly[gvk] {
+		return b.MetadataWatcher(ctx, gvk, name, cb)
+	}
 	c, err := b.cacheFactory.ForKind(gvk)
 	if err != nil {
 		return err
@@ -132,6 +163,20 @@ func (b *Backend) Watcher(ctx context.Context, gvk schema.GroupVersionKind, name
 	if err := b.addIndexer(ctx, gvk); err != nil {
 		return err
 	}
+	return b.registerHandler(ctx, c, gvk, name, cb)
+}
+
+// MetadataWatcher watches the kind with an informer that only caches object metadata. The callback is passed a
+// *metav1.PartialObjectMetadata.
+func (b *Backend) MetadataWatcher(ctx context.Context, gvk schema.GroupVersionKind, name string, cb backend.Callback) error {
+	c, err := b.cacheFactory.ForMetadataKind(gvk)
+	if err != nil {
+		return err
+	}
+	return b.registerHandler(ctx, c, gvk, name, cb)
+}
+
+func (b *Backend) registerHandler(ctx context.Context, c SharedController, gvk schema.GroupVersionKind, name string, cb backend.Callback) error {
 	handler := SharedControllerHandlerFunc(func(key string, obj runtime.Object) (runtime.Object, error) {
 		return cb(gvk, key, obj)
 	})
@@ -139,9 +184,82 @@ func (b *Backend) Watcher(ctx context.Context, gvk schema.GroupVersionKind, name
 		return err
 	}
 
-	if b.hasStarted() {
-		return c.Start(ctx, DefaultThreadiness)
+	if ctx, ok := b.startedContext(); ok {
+		// Controllers are started with the backend context, not the context of the handler, because they are shared by
+		// all handlers and are stopped when the last handler is removed.
+		return c.Start(ctx, 0)
+	}
+	return nil
+}
+
+// Workers returns the number of workers processing keys for the kind.
+func (b *Backend) Workers(gvk schema.GroupVersionKind) int {
+	return b.cacheFactory.Workers(gvk)
+}
+
+// HasSynced returns true if the informer for the kind has synced. Kinds without an informer are reported as not
+// synced; informers are never created by this call.
+func (b *Backend) HasSynced(_ context.Context, gvk schema.GroupVersionKind) (bool, error) {
+	informer := b.informerFor(gvk)
+	if informer == nil {
+		return false, nil
+	}
+	return informer.HasSynced(), nil
+}
+
+// OnDelete calls cb with the key of each object of the kind that is deleted from the cache until ctx is done. The kind
+// must already have a controller, for example by registering a watcher first.
+func (b *Backend) OnDelete(ctx context.Context, gvk schema.GroupVersionKind, cb func(key string)) error {
+	informer := b.informerFor(gvk)
+	if informer == nil {
+		return fmt.Errorf("no informer for %v", gvk)
+	}
+
+	registration, err := informer.AddEventHandler(kcache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(obj interface{}) {
+			if key, err := kcache.DeletionHandlingMetaNamespaceKeyFunc(obj); err == nil {
+				cb(key)
+			}
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	context.AfterFunc(ctx, func() {
+		_ = informer.RemoveEventHandler(registration)
+	})
+	return nil
+}
+
+// informerFor returns the existing informer of the controller for the kind, or nil if there is none.
+func (b *Backend) informerFor(gvk schema.GroupVersionKind) cache.Informer {
+	l, ok := b.cacheFactory.(informerLookup)
+	if !ok {
+		return nil
+	}
+	informer, _ := l.existingInformer(gvk, b.metadataOnly[gvk])
+	return informer
+}
+
+// StartCache starts the cache with a context that outlives the controllers so that they can be stopped and started
+// again, for example when leadership is lost and regained.
+func (b *Backend) StartCache(ctx context.Context) {
+	b.cacheFactory.StartCache(ctx)
+}
+
+// Drain stops processing new keys and waits until the keys being processed are done or the context is done.
+func (b *Backend) Drain(ctx context.Context) error {
+	return b.cacheFactory.Drain(ctx)
+}
+
+// SetWorkers changes the number of workers processing keys for the kind. Running controllers are adjusted
+// immediately.
+func (b *Backend) SetWorkers(gvk schema.GroupVersionKind, workers int) error {
+	if workers <= 0 {
+		return fmt.Errorf("workers for %v must be positive, got %d", gvk, workers)
 	}
+	b.cacheFactory.SetWorkers(gvk, workers)
 	return nil
 }
 
@@ -154,7 +272,7 @@ func (b *Backend) IsObjectNamespaced(obj runtime.Object) (bool, error) {
 }
 
 func (b *Backend) GVKForObject(obj runtime.Object, scheme *runtime.Scheme) (schema.GroupVersionKind, error) {
-	return apiutil.GVKForObject(uncached.Unwrap(obj), scheme)
+	return apiutil.GVKForObject(metadata.Unwrap(uncached.Unwrap(obj)), scheme)
 }
 
 func (b *Backend) IndexField(ctx context.Context, obj kclient.Object, field string, extractValue kclient.IndexerFunc) error {
@@ -169,8 +287,8 @@ func (b *Backend) GetInformerForKind(ctx context.Context, gvk schema.GroupVersio
 	return i.(kcache.SharedIndexInformer), nil
 }
 
-func (b *Backend) hasStarted() bool {
+func (b *Backend) startedContext() (context.Context, bool) {
 	b.startedLock.RLock()
 	defer b.startedLock.RUnlock()
-	return b.started
+	return b.ctx, b.started
 }
@@ -2,14 +2,17 @@ package runtime
 
 import (
 	"context"
-	"errors"
-	"strconv"
-	"sync"
-	"time"
+	"fmt"
+	"reflect"
+	"strings"
 
+	"github.com/acorn-io/baaah/pkg/fields"
+	"github.com/acorn-io/baaah/pkg/metadata"
 	"github.com/acorn-io/baaah/pkg/uncached"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
+	kfields "k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/watch"
@@ -17,101 +20,206 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 )
 
-const (
-	cacheDuration = 10 * time.Second
-)
+type cacheClient struct {
+	uncached     kclient.WithWatch
+	cached       kclient.Client
+	metadataOnly map[schema.GroupVersionKind]bool
+	writes       *writeTracker
+}
 
-type objectKey struct {
-	gvk             schema.GroupVersionKind
-	namespace, name string
+func newCacheClient(uncached kclient.WithWatch, cached kclient.Client, metadataOnly map[schema.GroupVersionKind]bool) *cacheClient {
+	return &cacheClient{
+		uncached:     uncached,
+		cached:       cached,
+		metadataOnly: metadataOnly,
+		writes:       newWriteTracker(metadataOnly),
+	}
 }
 
-type objectValue struct {
-	Object   kclient.Object
-	Inserted time.Time
+func (c *cacheClient) startPurge(ctx context.Context) {
+	c.writes.startPurge(ctx)
 }
 
-type cacheClient struct {
-	uncached kclient.WithWatch
-	cached   kclient.Client
+// gvkFor returns the kind of the object, or of the items if the object is a list.
+func (c *cacheClient) gvkFor(obj runtime.Object) (schema.GroupVersionKind, error) {
+	gvk, err := apiutil.GVKForObject(metadata.Unwrap(obj), c.Scheme())
+	if err != nil {
+		return gvk, err
+	}
+	if _, ok := obj.(kclient.ObjectList); ok {
+		gvk.Kind = strings.TrimSuffix(gvk.Kind, "List")
+	}
+	return gvk, nil
+}
 
-	recent     map[objectKey]objectValue
-	recentLock sync.Mutex
+// isMetadataOnly returns true if reads of the object should be served from the metadata-only cache.
+func (c *cacheClient) isMetadataOnly(gvk schema.GroupVersionKind, obj runtime.Object) bool {
+	if metadata.IsWrapped(obj) {
+		return true
+	}
+	// A PartialObjectMetadata(List) can be read from the cache directly.
+	return c.metadataOnly[gvk] && !metadata.IsMetadataOnly(obj)
 }
 
-func newer(oldRV, newRV string) bool {
-	if len(oldRV) == len(newRV) {
-		return oldRV < newRV
+func (c *cacheClient) notFound(gvk schema.GroupVersionKind, name string) error {
+	gr := schema.GroupResource{Group: gvk.Group, Resource: strings.ToLower(gvk.Kind)}
+	if mapping, err := c.RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version); err == nil {
+		gr = mapping.Resource.GroupResource()
 	}
-	oldI, err := strconv.Atoi(oldRV)
-	if err != nil {
-		return true
+	return apierrors.NewNotFound(gr, name)
+}
+
+func (c *cacheClient) getMetadata(ctx context.Context, gvk schema.GroupVersionKind, key kclient.ObjectKey, obj kclient.Object, opts ...kclient.GetOption) error {
+	partial := metadata.NewPartialObject(gvk)
+	err := c.cached.Get(ctx, key, partial)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
 	}
-	newI, err := strconv.Atoi(newRV)
-	if err != nil {
-		return false
+
+	if p, ok := c.writes.get(toObjectKey(gvk, key.Namespace, key.Name)); ok {
+		if p.object == nil {
+			return c.notFound(gvk, key.Name)
+		}
+		if err := convertInto(partial, p.object); err != nil {
+			return err
+		}
+	} else if apierrors.IsNotFound(err) {
+		if err := c.uncached.Get(ctx, key, partial, opts...); err != nil {
+			return err
+		}
 	}
-	return oldI < newI
+
+	return convertInto(obj, partial)
 }
 
-func newCacheClient(uncached kclient.WithWatch, cached kclient.Client) *cacheClient {
-	return &cacheClient{
-		uncached: uncached,
-		cached:   cached,
-		recent:   map[objectKey]objectValue{},
+func (c *cacheClient) listMetadata(ctx context.Context, gvk schema.GroupVersionKind, list kclient.ObjectList, opts ...kclient.ListOption) error {
+	partialList := metadata.NewPartialObjectList(gvk)
+	if err := c.cached.List(ctx, partialList, opts...); err != nil {
+		return err
+	}
+	if err := c.mergePending(gvk, partialList, opts...); err != nil {
+		return err
+	}
+
+	items := make([]runtime.Object, 0, len(partialList.Items))
+	for i := range partialList.Items {
+		item, err := newObject(c.Scheme(), gvk)
+		if err != nil {
+			return err
+		}
+		if err := convertInto(item, &partialList.Items[i]); err != nil {
+			return err
+		}
+		items = append(items, item)
 	}
+
+	list.SetResourceVersion(partialList.GetResourceVersion())
+	list.SetContinue(partialList.GetContinue())
+	return meta.SetList(list, items)
 }
 
-func (c *cacheClient) startPurge(ctx context.Context) {
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-time.After(cacheDuration):
-			}
+// mergePending updates the list with the writes that have not yet been observed by the cache.
+func (c *cacheClient) mergePending(gvk schema.GroupVersionKind, list kclient.ObjectList, opts ...kclient.ListOption) error {
+	pending := c.writes.pendingForKind(gvk)
+	if len(pending) == 0 {
+		return nil
+	}
+
+	listOpts := &kclient.ListOptions{}
+	listOpts.ApplyOptions(opts)
+
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return err
+	}
+
+	var (
+		seen   = map[objectKey]bool{}
+		result = make([]runtime.Object, 0, len(items))
+	)
+	for _, item := range items {
+		m, err := meta.Accessor(item)
+		if err != nil {
+			return err
+		}
+		key := toObjectKey(gvk, m.GetNamespace(), m.GetName())
+		p, ok := pending[key]
+		if !ok {
+			result = append(result, item)
+			continue
+		}
+
+		seen[key] = true
+		if p.object == nil || !matchesListOptions(p.object, listOpts) {
+			continue
+		}
+		if m.GetResourceVersion() == p.object.GetResourceVersion() {
+			result = append(result, item)
+			continue
+		}
+		newItem, err := newListItem(list)
+		if err != nil {
+			return err
+		}
+		if err := convertInto(newItem, p.object); err != nil {
+			return err
+		}
+		result = append(result, newItem)
+	}
 
-			now := time.Now()
-			c.recentLock.Lock()
-			for k, v := range c.recent {
-				if v.Inserted.Add(cacheDuration).Before(now) {
-					delete(c.recent, k)
-				}
+	// Created objects can't be placed in a paginated list reliably, so only add them to complete lists.
+	if list.GetContinue() == "" && listOpts.Continue == "" {
+		for key, p := range pending {
+			if seen[key] || p.object == nil || !matchesListOptions(p.object, listOpts) {
+				continue
 			}
-			c.recentLock.Unlock()
+			newItem, err := newListItem(list)
+			if err != nil {
+				return err
+			}
+			if err := convertInto(newItem, p.object); err != nil {
+				return err
+			}
+			result = append(result, newItem)
 		}
-	}()
+	}
+
+	return meta.SetList(list, result)
 }
 
-func (c *cacheClient) deleteStore(obj kclient.Object) {
-	gvk, err := apiutil.GVKForObject(obj, c.Scheme())
-	if err != nil {
-		return
+func matchesListOptions(obj kclient.Object, opts *kclient.ListOptions) bool {
+	if opts.Namespace != "" && obj.GetNamespace() != opts.Namespace {
+		return false
+	}
+	if opts.LabelSelector != nil && !opts.LabelSelector.Matches(labels.Set(obj.GetLabels())) {
+		return false
 	}
-	c.recentLock.Lock()
-	delete(c.recent, objectKey{
-		gvk:       gvk,
-		namespace: obj.GetNamespace(),
-		name:      obj.GetName(),
-	})
-	c.recentLock.Unlock()
+	if opts.FieldSelector != nil {
+		if f, ok := obj.(fields.Fields); ok {
+			return opts.FieldSelector.Matches(f)
+		}
+		return opts.FieldSelector.Matches(kfields.Set{
+			"metadata.name":      obj.GetName(),
+			"metadata.namespace": obj.GetNamespace(),
+		})
+	}
+	return true
 }
 
-func (c *cacheClient) store(obj kclient.Object) {
-	gvk, err := apiutil.GVKForObject(obj, c.Scheme())
-	if err != nil {
-		return
+func newListItem(list kclient.ObjectList) (runtime.Object, error) {
+	items := reflect.Indirect(reflect.ValueOf(list)).FieldByName("Items")
+	if !items.IsValid() || items.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("list %T does not have an Items slice", list)
 	}
-	c.recentLock.Lock()
-	c.recent[objectKey{
-		gvk:       gvk,
-		namespace: obj.GetNamespace(),
-		name:      obj.GetName(),
-	}] = objectValue{
-		Object:   obj.DeepCopyObject().(kclient.Object),
-		Inserted: time.Now(),
+	t := items.Type().Elem()
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
 	}
-	c.recentLock.Unlock()
+	obj, ok := reflect.New(t).Interface().(runtime.Object)
+	if !ok {
+		return nil, fmt.Errorf("items of list %T are not runtime.Object", list)
+	}
+	return obj, nil
 }
 
 func (c *cacheClient) Get(ctx context.Context, key kclient.ObjectKey, obj kclient.Object, opts ...kclient.GetOption) error {
@@ -119,36 +227,37 @@ func (c *cacheClient) Get(ctx context.Context, key kclient.ObjectKey, obj kclien
 		return c.uncached.Get(ctx, key, u.Object, opts...)
 	}
 
-	getErr := c.cached.Get(ctx, key, obj)
-	if getErr != nil && !apierrors.IsNotFound(getErr) {
-		return getErr
+	gvk, err := c.gvkFor(obj)
+	if err != nil {
+		return err
 	}
 
-	gvk, err := apiutil.GVKForObject(obj, c.Scheme())
-	if err != nil {
-		return errors.Join(getErr, err)
+	objKey := toObjectKey(gvk, key.Namespace, key.Name)
+	if timeout, ok := waitForCacheTimeout(opts); ok {
+		c.writes.wait(ctx, objKey, timeout)
 	}
 
-	cacheKey := objectKey{
-		gvk:       gvk,
-		namespace: obj.GetNamespace(),
-		name:      obj.GetName(),
+	if c.isMetadataOnly(gvk, obj) {
+		return c.getMetadata(ctx, gvk, key, metadata.Unwrap(obj).(kclient.Object), opts...)
 	}
 
-	c.recentLock.Lock()
-	cachedObj, ok := c.recent[cacheKey]
-	c.recentLock.Unlock()
+	getErr := c.cached.Get(ctx, key, obj)
+	if getErr != nil && !apierrors.IsNotFound(getErr) {
+		return getErr
+	}
 
-	if apierrors.IsNotFound(getErr) {
-		if ok {
-			return CopyInto(obj, cachedObj.Object)
-		} else {
-			return c.uncached.Get(ctx, key, obj, opts...)
+	if p, ok := c.writes.get(objKey); ok {
+		if p.object == nil {
+			return c.notFound(gvk, key.Name)
 		}
+		if getErr == nil && obj.GetResourceVersion() == p.object.GetResourceVersion() {
+			return nil
+		}
+		return convertInto(obj, p.object)
 	}
 
-	if ok && newer(obj.GetResourceVersion(), cachedObj.Object.GetResourceVersion()) {
-		return CopyInto(obj, cachedObj.Object)
+	if apierrors.IsNotFound(getErr) {
+		return c.uncached.Get(ctx, key, obj, opts...)
 	}
 
 	return nil
@@ -158,7 +267,37 @@ func (c *cacheClient) List(ctx context.Context, list kclient.ObjectList, opts ..
 	if u, ok := list.(*uncached.HolderList); ok {
 		return c.uncached.List(ctx, u.ObjectList, opts...)
 	}
-	return c.cached.List(ctx, list, opts...)
+
+	gvk, err := c.gvkFor(list)
+	if err != nil {
+		return err
+	}
+
+	if c.isMetadataOnly(gvk, list) {
+		return c.listMetadata(ctx, gvk, metadata.UnwrapList(list), opts...)
+	}
+
+	if err := c.cached.List(ctx, list, opts...); err != nil {
+		return err
+	}
+	return c.mergePending(gvk, list, opts...)
+}
+
+// written records the result of a write so that reads reflect it until the cache has observed it.
+func (c *cacheClient) written(ctx context.Context, obj kclient.Object) {
+	gvk, err := apiutil.GVKForObject(obj, c.Scheme())
+	if err != nil {
+		return
+	}
+	c.writes.written(ctx, gvk, obj)
+}
+
+func (c *cacheClient) deleted(ctx context.Context, obj kclient.Object) {
+	gvk, err := apiutil.GVKForObject(obj, c.Scheme())
+	if err != nil {
+		return
+	}
+	c.writes.deleted(ctx, gvk, obj)
 }
 
 func (c *cacheClient) Create(ctx context.Context, obj kclient.Object, opts ...kclient.CreateOption) error {
@@ -169,7 +308,7 @@ func (c *cacheClient) Create(ctx context.Context, obj kclient.Object, opts ...kc
 	if err != nil {
 		return err
 	}
-	c.store(obj)
+	c.written(ctx, obj)
 	return nil
 }
 
@@ -181,7 +320,7 @@ func (c *cacheClient) Delete(ctx context.Context, obj kclient.Object, opts ...kc
 	if err != nil {
 		return err
 	}
-	c.deleteStore(obj)
+	c.deleted(ctx, obj)
 	return nil
 }
 
@@ -193,7 +332,7 @@ func (c *cacheClient) Update(ctx context.Context, obj kclient.Object, opts ...kc
 	if err != nil {
 		return err
 	}
-	c.store(obj)
+	c.written(ctx, obj)
 	return nil
 }
 
@@ -205,7 +344,7 @@ func (c *cacheClient) Patch(ctx context.Context, obj kclient.Object, patch kclie
 	if err != nil {
 		return err
 	}
-	c.store(obj)
+	c.written(ctx, obj)
 	return nil
 }
 
@@ -260,7 +399,7 @@ func (s *subResourceClient) Update(ctx context.Context, obj kclient.Object, opts
 	if err != nil {
 		return err
 	}
-	s.c.store(obj)
+	s.c.written(ctx, uncached.Unwrap(obj).(kclient.Object))
 	return nil
 }
 
@@ -269,6 +408,6 @@ func (s *subResourceClient) Patch(ctx context.Context, obj kclient.Object, patch
 	if err != nil {
 		return err
 	}
-	s.c.store(obj)
+	s.c.written(ctx, uncached.Unwrap(obj).(kclient.Object))
 	return nil
 }
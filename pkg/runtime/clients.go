@@ -1,12 +1,19 @@
 package runtime
 
 import (
+	"fmt"
+	"maps"
 	"time"
 
 	"github.com/acorn-io/baaah/pkg/mapper"
+	"github.com/acorn-io/baaah/pkg/metadata"
 	"github.com/acorn-io/baaah/pkg/runtime/multi"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/rest"
+	kcache "k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -19,6 +26,44 @@ type Runtime struct {
 type Config struct {
 	Rest      *rest.Config
 	Namespace string
+	// ByGVK configures the cache for individual kinds. Kinds that are not present use the Namespace above and
+	// cache every object.
+	ByGVK map[schema.GroupVersionKind]CacheConfig
+
+	// DefaultWorkers is the number of workers processing keys for each kind. Defaults to DefaultThreadiness. This is
+	// only used on the default Config.
+	DefaultWorkers int
+	// KindWorkers overrides DefaultWorkers for the given kinds.
+	KindWorkers map[schema.GroupVersionKind]int
+	// DefaultRateLimiter is used to re-enqueue keys that fail to process. Defaults to an exponential backoff from
+	// 500ms to 15 minutes. This is only used on the default Config.
+	DefaultRateLimiter workqueue.RateLimiter
+	// KindRateLimiter overrides DefaultRateLimiter for the given kinds.
+	KindRateLimiter map[schema.GroupVersionKind]workqueue.RateLimiter
+	// DefaultPriorityQueue configures the order in which keys are processed. By default, changes observed by
+	// informers are processed before triggers and replays, which are processed before resyncs and the initial list.
+	// This is only used on the default Config.
+	DefaultPriorityQueue PriorityQueueOptions
+	// KindPriorityQueue overrides DefaultPriorityQueue for the given kinds.
+	KindPriorityQueue map[schema.GroupVersionKind]PriorityQueueOptions
+}
+
+// CacheConfig restricts and transforms the objects of a single kind that are stored in the cache.
+type CacheConfig struct {
+	// Namespaces limits the cache to objects in these namespaces. If empty, Config.Namespace is used. This must be
+	// empty for cluster scoped kinds.
+	Namespaces []string
+	// LabelSelector limits the cache to objects matching this selector.
+	LabelSelector labels.Selector
+	// FieldSelector limits the cache to objects matching this selector. Only fields supported by the API server for
+	// the kind can be used.
+	FieldSelector fields.Selector
+	// Transform is called on each object before it is stored in the cache. See StripManagedFields and
+	// ChainTransforms for common transforms.
+	Transform kcache.TransformFunc
+	// MetadataOnly will only cache the metadata of the objects of this kind. Handlers and reads through the
+	// client will receive typed objects that only have their TypeMeta and ObjectMeta populated.
+	MetadataOnly bool
 }
 
 func NewRuntime(cfg *rest.Config, scheme *runtime.Scheme) (*Runtime, error) {
@@ -37,8 +82,34 @@ func NewRuntimeWithConfigs(defaultConfig Config, apiGroupConfigs map[string]Conf
 	clients := make(map[string]client.WithWatch, len(apiGroupConfigs))
 	cachedClients := make(map[string]client.Client, len(apiGroupConfigs))
 	caches := make(map[string]cache.Cache, len(apiGroupConfigs))
+	metadataOnly := metadataOnlyGVKs(defaultConfig)
+	kindWorkers := maps.Clone(defaultConfig.KindWorkers)
+	kindRateLimiter := maps.Clone(defaultConfig.KindRateLimiter)
+	kindPriorityQueue := maps.Clone(defaultConfig.KindPriorityQueue)
 
 	for key, cfg := range apiGroupConfigs {
+		for gvk := range metadataOnlyGVKs(cfg) {
+			metadataOnly[gvk] = true
+		}
+		for gvk, workers := range cfg.KindWorkers {
+			if kindWorkers == nil {
+				kindWorkers = map[schema.GroupVersionKind]int{}
+			}
+			kindWorkers[gvk] = workers
+		}
+		for gvk, rateLimiter := range cfg.KindRateLimiter {
+			if kindRateLimiter == nil {
+				kindRateLimiter = map[schema.GroupVersionKind]workqueue.RateLimiter{}
+			}
+			kindRateLimiter[gvk] = rateLimiter
+		}
+		for gvk, priorityQueue := range cfg.KindPriorityQueue {
+			if kindPriorityQueue == nil {
+				kindPriorityQueue = map[schema.GroupVersionKind]PriorityQueueOptions{}
+			}
+			kindPriorityQueue[gvk] = priorityQueue
+		}
+
 		uncachedClient, cachedClient, theCache, err := getClients(cfg, scheme)
 		if err != nil {
 			return nil, err
@@ -58,16 +129,26 @@ func NewRuntimeWithConfigs(defaultConfig Config, apiGroupConfigs map[string]Conf
 	aggCachedClient := multi.NewClient(cachedClient, cachedClients)
 	aggCache := multi.NewCache(scheme, theCache, caches)
 
-	factory := NewSharedControllerFactory(aggUncachedClient, aggCache, &SharedControllerFactoryOptions{
-		// In baaah this is only invoked when a key fails to process
-		DefaultRateLimiter: workqueue.NewMaxOfRateLimiter(
+	// In baaah this is only invoked when a key fails to process
+	rateLimiter := defaultConfig.DefaultRateLimiter
+	if rateLimiter == nil {
+		rateLimiter = workqueue.NewMaxOfRateLimiter(
 			// This will go .5, 1, 2, 4, 8 seconds, etc up until 15 minutes
 			workqueue.NewItemExponentialFailureRateLimiter(500*time.Millisecond, 15*time.Minute),
-		),
+		)
+	}
+
+	factory := NewSharedControllerFactory(aggUncachedClient, aggCache, &SharedControllerFactoryOptions{
+		DefaultRateLimiter:   rateLimiter,
+		DefaultWorkers:       defaultConfig.DefaultWorkers,
+		DefaultPriorityQueue: defaultConfig.DefaultPriorityQueue,
+		KindRateLimiter:      kindRateLimiter,
+		KindWorkers:          kindWorkers,
+		KindPriorityQueue:    kindPriorityQueue,
 	})
 
 	return &Runtime{
-		Backend: newBackend(factory, newCacheClient(aggUncachedClient, aggCachedClient), aggCache),
+		Backend: newBackend(factory, newCacheClient(aggUncachedClient, aggCachedClient, metadataOnly), aggCache),
 	}, nil
 }
 
@@ -91,10 +172,16 @@ func getClients(cfg Config, scheme *runtime.Scheme) (uncachedClient client.WithW
 		namespaces[cfg.Namespace] = cache.Config{}
 	}
 
+	byObject, err := toByObject(cfg.ByGVK, scheme)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
 	theCache, err = cache.New(cfg.Rest, cache.Options{
 		Mapper:            mapper,
 		Scheme:            scheme,
 		DefaultNamespaces: namespaces,
+		ByObject:          byObject,
 	})
 	if err != nil {
 		return nil, nil, nil, err
@@ -113,3 +200,53 @@ func getClients(cfg Config, scheme *runtime.Scheme) (uncachedClient client.WithW
 
 	return uncachedClient, cachedClient, theCache, nil
 }
+
+func metadataOnlyGVKs(cfg Config) map[schema.GroupVersionKind]bool {
+	result := map[schema.GroupVersionKind]bool{}
+	for gvk, c := range cfg.ByGVK {
+		if c.MetadataOnly {
+			result[gvk] = true
+		}
+	}
+	return result
+}
+
+func toByObject(configs map[schema.GroupVersionKind]CacheConfig, scheme *runtime.Scheme) (map[client.Object]cache.ByObject, error) {
+	if len(configs) == 0 {
+		return nil, nil
+	}
+
+	result := make(map[client.Object]cache.ByObject, len(configs))
+	for gvk, cfg := range configs {
+		var cObj client.Object
+		if cfg.MetadataOnly {
+			cObj = metadata.NewPartialObject(gvk)
+		} else {
+			obj, err := newObject(scheme, gvk)
+			if err != nil {
+				return nil, err
+			}
+			var ok bool
+			cObj, ok = obj.(client.Object)
+			if !ok {
+				return nil, fmt.Errorf("type %T for %v is not a client.Object", obj, gvk)
+			}
+			cObj.GetObjectKind().SetGroupVersionKind(gvk)
+		}
+
+		byObject := cache.ByObject{
+			Label:     cfg.LabelSelector,
+			Field:     cfg.FieldSelector,
+			Transform: cfg.Transform,
+		}
+		if len(cfg.Namespaces) > 0 {
+			byObject.Namespaces = make(map[string]cache.Config, len(cfg.Namespaces))
+			for _, ns := range cfg.Namespaces {
+				byObject.Namespaces[ns] = cache.Config{}
+			}
+		}
+		result[cObj] = byObject
+	}
+
+	return result, nil
+}
@@ -0,0 +1,330 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/acorn-io/baaah/pkg/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	clientgocache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// maxPendingAge is how long a write is tracked if the informer never observes it, for example because the informer
+// relisted and skipped over the version that was written.
+const maxPendingAge = time.Minute
+
+// WaitForCache is a GetOption that makes a Get block, for at most the given duration, until the cache has observed
+// all writes made through this client to the object. If the timeout expires, the last written object is returned.
+type WaitForCache time.Duration
+
+func (WaitForCache) ApplyToGet(*kclient.GetOptions) {}
+
+func waitForCacheTimeout(opts []kclient.GetOption) (time.Duration, bool) {
+	for _, opt := range opts {
+		if w, ok := opt.(WaitForCache); ok {
+			return time.Duration(w), true
+		}
+	}
+	return 0, false
+}
+
+type objectKey struct {
+	gvk             schema.GroupVersionKind
+	namespace, name string
+}
+
+type pendingWrite struct {
+	// object is the object returned by the API server for the write. It is nil for deletes.
+	object   kclient.Object
+	uid      types.UID
+	inserted time.Time
+	done     chan struct{}
+}
+
+type observer struct {
+	informer     cache.Informer
+	ctx          context.Context
+	registration clientgocache.ResourceEventHandlerRegistration
+}
+
+// writeTracker tracks writes made through the client until the informer for the kind observes them. This allows reads
+// from the cache to reflect writes made by this client, which is required for handlers to be idempotent. Only writes to
+// kinds with a running informer are tracked.
+type writeTracker struct {
+	informers    informerLookup
+	metadataOnly map[schema.GroupVersionKind]bool
+
+	lock      sync.Mutex
+	pending   map[objectKey]*pendingWrite
+	observers map[schema.GroupVersionKind]observer
+}
+
+func newWriteTracker(metadataOnly map[schema.GroupVersionKind]bool) *writeTracker {
+	return &writeTracker{
+		metadataOnly: metadataOnly,
+		pending:      map[objectKey]*pendingWrite{},
+		observers:    map[schema.GroupVersionKind]observer{},
+	}
+}
+
+func (w *writeTracker) startPurge(ctx context.Context) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(maxPendingAge):
+			}
+
+			now := time.Now()
+			w.lock.Lock()
+			for k, p := range w.pending {
+				if p.inserted.Add(maxPendingAge).Before(now) {
+					w.resolveLocked(k, p)
+				}
+			}
+			w.lock.Unlock()
+		}
+	}()
+}
+
+// written records an object returned by the API server for a create, update, or patch.
+func (w *writeTracker) written(ctx context.Context, gvk schema.GroupVersionKind, obj kclient.Object) {
+	w.track(ctx, gvk, obj, obj.DeepCopyObject().(kclient.Object))
+}
+
+// deleted records an object that was deleted. Objects with finalizers are not tracked because they will continue to
+// exist until the finalizers are removed.
+func (w *writeTracker) deleted(ctx context.Context, gvk schema.GroupVersionKind, obj kclient.Object) {
+	if len(obj.GetFinalizers()) > 0 {
+		w.lock.Lock()
+		defer w.lock.Unlock()
+		key := toObjectKey(gvk, obj.GetNamespace(), obj.GetName())
+		if p, ok := w.pending[key]; ok {
+			w.resolveLocked(key, p)
+		}
+		return
+	}
+	w.track(ctx, gvk, obj, nil)
+}
+
+func (w *writeTracker) track(ctx context.Context, gvk schema.GroupVersionKind, obj, pendingObj kclient.Object) {
+	if err := w.observe(gvk); err != nil {
+		log.Debugf("not tracking write to %s/%s [%v]: %v", obj.GetNamespace(), obj.GetName(), gvk, err)
+		return
+	}
+
+	key := toObjectKey(gvk, obj.GetNamespace(), obj.GetName())
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if p, ok := w.pending[key]; ok {
+		w.resolveLocked(key, p)
+	}
+	w.pending[key] = &pendingWrite{
+		object:   pendingObj,
+		uid:      obj.GetUID(),
+		inserted: time.Now(),
+		done:     make(chan struct{}),
+	}
+}
+
+// observe ensures that the tracker receives the events of the running informer for the kind. Informers are never
+// created by the tracker, and it stops observing an informer when its controller stops.
+func (w *writeTracker) observe(gvk schema.GroupVersionKind) error {
+	if w.informers == nil {
+		return fmt.Errorf("no informers to observe")
+	}
+	informer, ctx := w.informers.existingInformer(gvk, w.metadataOnly[gvk])
+	if informer == nil || ctx == nil {
+		return fmt.Errorf("no running informer")
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	existing, ok := w.observers[gvk]
+	if ok && existing.informer == informer && existing.ctx == ctx {
+		return nil
+	} else if ok {
+		_ = existing.informer.RemoveEventHandler(existing.registration)
+	}
+
+	registration, err := informer.AddEventHandler(clientgocache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			w.onObserved(gvk, obj, false)
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			w.onObserved(gvk, obj, false)
+		},
+		DeleteFunc: func(obj interface{}) {
+			w.onObserved(gvk, obj, true)
+		},
+	})
+	if err != nil {
+		delete(w.observers, gvk)
+		return err
+	}
+
+	w.observers[gvk] = observer{
+		informer:     informer,
+		ctx:          ctx,
+		registration: registration,
+	}
+	context.AfterFunc(ctx, func() {
+		w.stopObserving(ctx, gvk)
+	})
+	return nil
+}
+
+// stopObserving removes the event handler of the tracker from the informer of a controller that stopped. The pending
+// writes of the kind are resolved because they will not be observed anymore.
+func (w *writeTracker) stopObserving(ctx context.Context, gvk schema.GroupVersionKind) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	o, ok := w.observers[gvk]
+	if !ok || o.ctx != ctx {
+		return
+	}
+	_ = o.informer.RemoveEventHandler(o.registration)
+	delete(w.observers, gvk)
+
+	for k, p := range w.pending {
+		if k.gvk == gvk {
+			w.resolveLocked(k, p)
+		}
+	}
+}
+
+func (w *writeTracker) onObserved(gvk schema.GroupVersionKind, obj interface{}, deleted bool) {
+	if tombstone, ok := obj.(clientgocache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	mObj, ok := obj.(metav1.Object)
+	if !ok {
+		return
+	}
+
+	key := toObjectKey(gvk, mObj.GetNamespace(), mObj.GetName())
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	p, ok := w.pending[key]
+	if !ok {
+		return
+	}
+
+	switch {
+	case deleted:
+		// The object no longer exists, so the cache is at least as new as the write.
+	case p.uid != "" && p.uid != mObj.GetUID():
+		// The object was recreated after the write.
+	case p.object == nil && mObj.GetDeletionTimestamp() != nil:
+		// The object has finalizers on the server, so the cache shows it as terminating until they are removed.
+	case p.object == nil:
+		// A pending delete is otherwise only resolved by observing the delete.
+		return
+	case !newerOrEqual(mObj.GetResourceVersion(), p.object.GetResourceVersion()):
+		return
+	}
+
+	w.resolveLocked(key, p)
+}
+
+// newerOrEqual returns true if the observed resource version is the same as or newer than the written one. Resource
+// versions are opaque, but the API server uses integers; other versions are only compared for equality.
+func newerOrEqual(observed, written string) bool {
+	if observed == written {
+		return true
+	}
+	o, err := strconv.ParseUint(observed, 10, 64)
+	if err != nil {
+		return false
+	}
+	w, err := strconv.ParseUint(written, 10, 64)
+	return err == nil && o >= w
+}
+
+func (w *writeTracker) resolveLocked(key objectKey, p *pendingWrite) {
+	close(p.done)
+	delete(w.pending, key)
+}
+
+// get returns the pending write for the object, if any. A pending write with a nil object is a pending delete.
+func (w *writeTracker) get(key objectKey) (*pendingWrite, bool) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	p, ok := w.pending[key]
+	return p, ok
+}
+
+// wait blocks until the cache has observed the pending write for the object, the timeout expires, or the context is
+// done.
+func (w *writeTracker) wait(ctx context.Context, key objectKey, timeout time.Duration) {
+	p, ok := w.get(key)
+	if !ok {
+		return
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-p.done:
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// pendingForKind returns the pending writes for the kind keyed by namespace and name.
+func (w *writeTracker) pendingForKind(gvk schema.GroupVersionKind) map[objectKey]*pendingWrite {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	var result map[objectKey]*pendingWrite
+	for k, p := range w.pending {
+		if k.gvk == gvk {
+			if result == nil {
+				result = map[objectKey]*pendingWrite{}
+			}
+			result[k] = p
+		}
+	}
+	return result
+}
+
+func toObjectKey(gvk schema.GroupVersionKind, namespace, name string) objectKey {
+	return objectKey{
+		gvk:       gvk,
+		namespace: namespace,
+		name:      name,
+	}
+}
+
+// convertInto copies src into dst even if they are not the same type, such as a typed object into an unstructured
+// object or a PartialObjectMetadata.
+func convertInto(dst, src runtime.Object) error {
+	if reflect.TypeOf(dst) == reflect.TypeOf(src) {
+		return CopyInto(dst, src)
+	}
+	data, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	v := reflect.ValueOf(dst)
+	v.Elem().Set(reflect.Zero(v.Elem().Type()))
+	return json.Unmarshal(data, dst)
+}
@@ -2,12 +2,14 @@ package runtime
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/acorn-io/baaah/pkg/log"
+	"github.com/acorn-io/baaah/pkg/metadata"
 	apierror "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -41,15 +43,20 @@ type Controller interface {
 	Enqueue(namespace, name string)
 	EnqueueAfter(namespace, name string, delay time.Duration)
 	EnqueueKey(key string)
+	// EnqueueKeyWithPriority enqueues the key to be processed ahead of or after keys with a different priority.
+	EnqueueKeyWithPriority(key string, priority Priority)
 	Cache() (cache.Cache, error)
 	Start(ctx context.Context, workers int) error
+	// SetWorkers changes the number of workers processing keys. If the controller is running, workers are started or
+	// stopped immediately; a stopped worker finishes the key it is processing first.
+	SetWorkers(workers int)
 }
 
 type controller struct {
 	startLock sync.Mutex
 
 	name         string
-	workqueue    workqueue.RateLimitingInterface
+	workqueue    *priorityQueue
 	rateLimiter  workqueue.RateLimiter
 	informer     cache.Informer
 	handler      Handler
@@ -59,47 +66,79 @@ type controller struct {
 	registration clientgocache.ResourceEventHandlerRegistration
 	obj          runtime.Object
 	cache        cache.Cache
+	metadataOnly bool
+	priority     PriorityQueueOptions
+	workers      int
+	runCtx       context.Context
+	workerStops  []context.CancelFunc
+	draining     bool
 }
 
 type startKey struct {
-	key   string
-	after time.Duration
+	key      string
+	after    time.Duration
+	priority Priority
 }
 
 type Options struct {
 	RateLimiter workqueue.RateLimiter
+	// MetadataOnly will use an informer that only caches the metadata of the objects. The handler will be passed a
+	// *metav1.PartialObjectMetadata.
+	MetadataOnly bool
+	// PriorityQueue configures the order in which keys are processed.
+	PriorityQueue PriorityQueueOptions
 }
 
 func New(gvk schema.GroupVersionKind, scheme *runtime.Scheme, cache cache.Cache, handler Handler, opts *Options) (Controller, error) {
 	opts = applyDefaultOptions(opts)
 
-	obj, err := newObject(scheme, gvk)
-	if err != nil {
-		return nil, err
+	var (
+		obj  runtime.Object
+		name = gvk.String()
+		err  error
+	)
+	if opts.MetadataOnly {
+		obj = metadata.NewPartialObject(gvk)
+		name += " (metadata)"
+	} else {
+		obj, err = newObject(scheme, gvk)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	informer, err := cache.GetInformerForKind(context.TODO(), gvk)
-	if err != nil {
-		return nil, err
+	controller := &controller{
+		gvk:          gvk,
+		name:         name,
+		handler:      handler,
+		cache:        cache,
+		obj:          obj,
+		rateLimiter:  opts.RateLimiter,
+		metadataOnly: opts.MetadataOnly,
+		priority:     opts.PriorityQueue,
 	}
 
-	controller := &controller{
-		gvk:         gvk,
-		name:        gvk.String(),
-		handler:     handler,
-		cache:       cache,
-		obj:         obj,
-		rateLimiter: opts.RateLimiter,
-		informer:    informer,
+	controller.informer, err = controller.getInformer(context.TODO())
+	if err != nil {
+		return nil, err
 	}
 
 	return controller, nil
 }
 
+func (c *controller) getInformer(ctx context.Context) (cache.Informer, error) {
+	if c.metadataOnly {
+		return c.cache.GetInformer(ctx, c.obj.(kclient.Object))
+	}
+	return c.cache.GetInformerForKind(ctx, c.gvk)
+}
+
 func newObject(scheme *runtime.Scheme, gvk schema.GroupVersionKind) (runtime.Object, error) {
 	obj, err := scheme.New(gvk)
 	if runtime.IsNotRegisteredError(err) {
-		return &unstructured.Unstructured{}, nil
+		u := &unstructured.Unstructured{}
+		u.SetGroupVersionKind(gvk)
+		return u, nil
 	}
 	return obj, err
 }
@@ -118,6 +157,25 @@ func applyDefaultOptions(opts *Options) *Options {
 	return &newOpts
 }
 
+type informerRemover interface {
+	removeInformer(ctx context.Context) error
+}
+
+func (c *controller) removeInformer(ctx context.Context) error {
+	return c.cache.RemoveInformer(ctx, c.obj.(kclient.Object))
+}
+
+type informerGetter interface {
+	currentInformer() cache.Informer
+}
+
+// currentInformer returns the informer the controller was created with, without creating one.
+func (c *controller) currentInformer() cache.Informer {
+	c.startLock.Lock()
+	defer c.startLock.Unlock()
+	return c.informer
+}
+
 func (c *controller) Cache() (cache.Cache, error) {
 	return c.cache, nil
 }
@@ -127,24 +185,20 @@ func (c *controller) GroupVersionKind() schema.GroupVersionKind {
 }
 
 func (c *controller) run(ctx context.Context, workers int) {
-	defer func() {
-		_ = c.informer.RemoveEventHandler(c.registration)
-	}()
-
 	c.startLock.Lock()
 	// we have to defer queue creation until we have a stopCh available because a workqueue
 	// will create a goroutine under the hood.  It we instantiate a workqueue we must have
 	// a mechanism to Shutdown it down.  Without the stopCh we don't know when to shutdown
 	// the queue and release the goroutine
-	c.workqueue = workqueue.NewNamedRateLimitingQueue(c.rateLimiter, c.name)
+	c.workqueue = newPriorityQueue(c.name, c.rateLimiter, c.priority)
 	for _, start := range c.startKeys {
-		if start.after == 0 {
-			c.workqueue.Add(start.key)
-		} else {
-			c.workqueue.AddAfter(start.key, start.after)
-		}
+		c.workqueue.AddAfterWithPriority(start.key, start.after, start.priority)
 	}
 	c.startKeys = nil
+	c.runCtx = ctx
+	c.draining = false
+	c.workers = workers
+	c.scaleWorkersLocked()
 	c.startLock.Unlock()
 
 	defer utilruntime.HandleCrash()
@@ -155,17 +209,84 @@ func (c *controller) run(ctx context.Context, workers int) {
 	// Start the informer factories to begin populating the informer caches
 	log.Infof("Starting %s controller", c.name)
 
-	for i := 0; i < workers; i++ {
+	<-ctx.Done()
+	c.startLock.Lock()
+	defer c.startLock.Unlock()
+	c.started = false
+	c.runCtx = nil
+	c.workerStops = nil
+	// Clear the registration in the same critical section so that a restart registers the handler again.
+	_ = c.informer.RemoveEventHandler(c.registration)
+	c.registration = nil
+	log.Infof("Shutting down %s workers", c.name)
+}
+
+func (c *controller) SetWorkers(workers int) {
+	c.startLock.Lock()
+	defer c.startLock.Unlock()
+
+	if c.workers == workers {
+		return
+	}
+	log.Infof("Setting %s workers to %d", c.name, workers)
+	c.workers = workers
+	c.scaleWorkersLocked()
+}
+
+// scaleWorkersLocked starts or stops workers until the number running matches c.workers. This is a no-op if the
+// controller is not running.
+func (c *controller) scaleWorkersLocked() {
+	if c.runCtx == nil || c.draining {
+		return
+	}
+
+	for len(c.workerStops) < c.workers {
+		ctx, cancel := context.WithCancel(c.runCtx)
+		c.workerStops = append(c.workerStops, cancel)
 		go wait.Until(func() {
 			c.runWorker(ctx)
 		}, time.Second, ctx.Done())
 	}
 
-	<-ctx.Done()
+	for len(c.workerStops) > c.workers {
+		last := len(c.workerStops) - 1
+		c.workerStops[last]()
+		c.workerStops = c.workerStops[:last]
+	}
+}
+
+type drainer interface {
+	drain(ctx context.Context) error
+}
+
+// drain stops the workers from processing new keys and waits until the keys being processed are done or the context
+// is done. Keys enqueued after this are dropped.
+func (c *controller) drain(ctx context.Context) error {
 	c.startLock.Lock()
-	defer c.startLock.Unlock()
-	c.started = false
-	log.Infof("Shutting down %s workers", c.name)
+	queue := c.workqueue
+	c.draining = true
+	for _, stop := range c.workerStops {
+		stop()
+	}
+	c.workerStops = nil
+	c.startLock.Unlock()
+
+	if queue == nil {
+		return nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		queue.ShutDownWithDrain()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("timed out waiting for %s workers to finish: %w", c.name, ctx.Err())
+	}
 }
 
 func (c *controller) Start(ctx context.Context, workers int) error {
@@ -177,7 +298,7 @@ func (c *controller) Start(ctx context.Context, workers int) error {
 	}
 
 	if c.informer == nil {
-		informer, err := c.cache.GetInformerForKind(ctx, c.gvk)
+		informer, err := c.getInformer(ctx)
 		if err != nil {
 			return err
 		}
@@ -189,12 +310,20 @@ func (c *controller) Start(ctx context.Context, workers int) error {
 	}
 
 	if c.registration == nil {
-		registration, err := c.informer.AddEventHandler(clientgocache.ResourceEventHandlerFuncs{
-			AddFunc: c.handleObject,
+		registration, err := c.informer.AddEventHandler(clientgocache.ResourceEventHandlerDetailedFuncs{
+			AddFunc: func(obj interface{}, isInInitialList bool) {
+				if isInInitialList {
+					c.handleObject(obj, PriorityLow)
+				} else {
+					c.handleObject(obj, PriorityHigh)
+				}
+			},
 			UpdateFunc: func(old, new interface{}) {
-				c.handleObject(new)
+				c.handleObject(new, PriorityHigh)
+			},
+			DeleteFunc: func(obj interface{}) {
+				c.handleObject(obj, PriorityHigh)
 			},
-			DeleteFunc: c.handleObject,
 		})
 		if err != nil {
 			return err
@@ -223,14 +352,28 @@ func (c *controller) runWorker(ctx context.Context) {
 }
 
 func (c *controller) processNextWorkItem(ctx context.Context) bool {
+	// A worker that has been stopped must not process another key.
+	if ctx.Err() != nil {
+		return false
+	}
+
 	obj, shutdown := c.workqueue.Get()
 
 	if shutdown {
 		return false
 	}
 
+	if ctx.Err() != nil {
+		// The worker was stopped while waiting for a key, so leave the key to the workers still running.
+		c.workqueue.Return(obj)
+		return false
+	}
+
 	if err := c.processSingleItem(ctx, obj); err != nil {
-		if !strings.Contains(err.Error(), "please apply your changes to the latest version and try again") {
+		if errors.Is(err, context.DeadlineExceeded) {
+			// The key has been requeued with backoff, so a timeout is not treated as a failure.
+			log.Infof("%v", err)
+		} else if !strings.Contains(err.Error(), "please apply your changes to the latest version and try again") {
 			log.Errorf("%v", err)
 		}
 		return true
@@ -254,7 +397,7 @@ func (c *controller) processSingleItem(ctx context.Context, obj interface{}) err
 	}
 	if err := c.syncHandler(ctx, key); err != nil {
 		c.workqueue.AddRateLimited(key)
-		return fmt.Errorf("error syncing '%s': %s, requeuing", key, err.Error())
+		return fmt.Errorf("error syncing '%s': %w, requeuing", key, err)
 	}
 
 	c.workqueue.Forget(obj)
@@ -287,13 +430,17 @@ func (c *controller) syncHandler(ctx context.Context, key string) error {
 }
 
 func (c *controller) EnqueueKey(key string) {
+	c.EnqueueKeyWithPriority(key, PriorityNormal)
+}
+
+func (c *controller) EnqueueKeyWithPriority(key string, priority Priority) {
 	c.startLock.Lock()
 	defer c.startLock.Unlock()
 
 	if c.workqueue == nil {
-		c.startKeys = append(c.startKeys, startKey{key: key})
+		c.startKeys = append(c.startKeys, startKey{key: key, priority: priority})
 	} else {
-		c.workqueue.Add(key)
+		c.workqueue.AddWithPriority(key, priority)
 	}
 }
 
@@ -304,7 +451,7 @@ func (c *controller) Enqueue(namespace, name string) {
 	defer c.startLock.Unlock()
 
 	if c.workqueue == nil {
-		c.startKeys = append(c.startKeys, startKey{key: key})
+		c.startKeys = append(c.startKeys, startKey{key: key, priority: PriorityNormal})
 	} else {
 		c.workqueue.AddRateLimited(key)
 	}
@@ -317,7 +464,7 @@ func (c *controller) EnqueueAfter(namespace, name string, duration time.Duration
 	defer c.startLock.Unlock()
 
 	if c.workqueue == nil {
-		c.startKeys = append(c.startKeys, startKey{key: key, after: duration})
+		c.startKeys = append(c.startKeys, startKey{key: key, after: duration, priority: PriorityNormal})
 	} else {
 		c.workqueue.AddAfter(key, duration)
 	}
@@ -340,7 +487,7 @@ func keyFunc(namespace, name string) string {
 	return namespace + "/" + name
 }
 
-func (c *controller) enqueue(obj interface{}) {
+func (c *controller) enqueue(obj interface{}, priority Priority) {
 	var key string
 	var err error
 	if key, err = clientgocache.MetaNamespaceKeyFunc(obj); err != nil {
@@ -349,14 +496,14 @@ func (c *controller) enqueue(obj interface{}) {
 	}
 	c.startLock.Lock()
 	if c.workqueue == nil {
-		c.startKeys = append(c.startKeys, startKey{key: key})
+		c.startKeys = append(c.startKeys, startKey{key: key, priority: priority})
 	} else {
-		c.workqueue.Add(key)
+		c.workqueue.AddWithPriority(key, priority)
 	}
 	c.startLock.Unlock()
 }
 
-func (c *controller) handleObject(obj interface{}) {
+func (c *controller) handleObject(obj interface{}, priority Priority) {
 	if _, ok := obj.(metav1.Object); !ok {
 		tombstone, ok := obj.(clientgocache.DeletedFinalStateUnknown)
 		if !ok {
@@ -370,5 +517,5 @@ func (c *controller) handleObject(obj interface{}) {
 		}
 		obj = newObj
 	}
-	c.enqueue(obj)
+	c.enqueue(obj, priority)
 }
@@ -24,6 +24,9 @@ func (n *errorController) EnqueueAfter(namespace, name string, delay time.Durati
 func (n *errorController) EnqueueKey(key string) {
 }
 
+func (n *errorController) EnqueueKeyWithPriority(key string, priority Priority) {
+}
+
 func (n *errorController) Cache() (cache.Cache, error) {
 	return nil, n.err
 }
@@ -31,3 +34,6 @@ func (n *errorController) Cache() (cache.Cache, error) {
 func (n *errorController) Start(ctx context.Context, workers int) error {
 	return nil
 }
+
+func (n *errorController) SetWorkers(workers int) {
+}
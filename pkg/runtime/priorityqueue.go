@@ -0,0 +1,314 @@
+package runtime
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Priority determines the order in which keys are processed. Keys with a higher priority are processed first.
+type Priority int
+
+const (
+	// PriorityLow is used for keys enqueued in bulk, such as the initial list of objects or periodic resyncs.
+	PriorityLow Priority = iota
+	// PriorityNormal is used for triggers, replays, and retries.
+	PriorityNormal
+	// PriorityHigh is used for changes observed by the informer.
+	PriorityHigh
+
+	priorityLevels = int(PriorityHigh) + 1
+
+	defaultStarvationLimit = 10
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	}
+	return strconv.Itoa(int(p))
+}
+
+// PriorityQueueOptions configure the priority queue of a controller.
+type PriorityQueueOptions struct {
+	// Disabled processes all keys in the order they are added regardless of priority.
+	Disabled bool
+	// StarvationLimit is the number of times a pending key may be passed over for keys of a higher priority before it
+	// is processed anyway. Defaults to 10.
+	StarvationLimit int
+}
+
+var queueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Subsystem: "baaah_workqueue",
+	Name:      "priority_depth",
+	Help:      "Current depth of a controller workqueue by priority",
+}, []string{"name", "priority"})
+
+func init() {
+	metrics.Registry.MustRegister(queueDepth)
+}
+
+// priorityQueue is a rate limited work queue that processes keys with a higher priority first. Like the client-go
+// workqueue, a key is never processed by more than one worker at a time and a key added while it is being processed is
+// processed again afterward. If a queued key is added with a higher priority, it is moved to the higher priority.
+// Unlike the client-go workqueue, keys that are still queued are not returned by Get after the queue is shut down.
+type priorityQueue struct {
+	rateLimiter     workqueue.RateLimiter
+	starvationLimit int
+	disabled        bool
+
+	cond         *sync.Cond
+	queues       [priorityLevels][]any
+	counts       [priorityLevels]int
+	skipped      [priorityLevels]int
+	queued       map[any]Priority
+	processing   map[any]Priority
+	requeue      map[any]Priority
+	shuttingDown bool
+	depth        [priorityLevels]prometheus.Gauge
+}
+
+func newPriorityQueue(name string, rateLimiter workqueue.RateLimiter, opts PriorityQueueOptions) *priorityQueue {
+	q := &priorityQueue{
+		rateLimiter:     rateLimiter,
+		starvationLimit: opts.StarvationLimit,
+		disabled:        opts.Disabled,
+		cond:            sync.NewCond(&sync.Mutex{}),
+		queued:          map[any]Priority{},
+		processing:      map[any]Priority{},
+		requeue:         map[any]Priority{},
+	}
+	if q.starvationLimit <= 0 {
+		q.starvationLimit = defaultStarvationLimit
+	}
+	for i := range q.depth {
+		q.depth[i] = queueDepth.WithLabelValues(name, Priority(i).String())
+		q.depth[i].Set(0)
+	}
+	return q
+}
+
+func (q *priorityQueue) level(p Priority) Priority {
+	if q.disabled || p < PriorityLow {
+		return PriorityLow
+	}
+	if p > PriorityHigh {
+		return PriorityHigh
+	}
+	return p
+}
+
+func (q *priorityQueue) Add(item any) {
+	q.AddWithPriority(item, PriorityNormal)
+}
+
+func (q *priorityQueue) AddWithPriority(item any, p Priority) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	q.addLocked(item, q.level(p))
+}
+
+func (q *priorityQueue) addLocked(item any, p Priority) {
+	if q.shuttingDown {
+		return
+	}
+
+	if _, ok := q.processing[item]; ok {
+		if existing, ok := q.requeue[item]; !ok || p > existing {
+			q.requeue[item] = p
+		}
+		return
+	}
+
+	if existing, ok := q.queued[item]; ok {
+		if p <= existing {
+			return
+		}
+		// The entry in the lower priority queue is now stale and will be skipped.
+		q.counts[existing]--
+		q.depth[existing].Set(float64(q.counts[existing]))
+	}
+
+	q.queued[item] = p
+	q.queues[p] = append(q.queues[p], item)
+	q.counts[p]++
+	q.depth[p].Set(float64(q.counts[p]))
+	q.cond.Signal()
+}
+
+func (q *priorityQueue) AddAfter(item any, duration time.Duration) {
+	q.AddAfterWithPriority(item, duration, PriorityNormal)
+}
+
+func (q *priorityQueue) AddAfterWithPriority(item any, duration time.Duration, p Priority) {
+	if duration <= 0 {
+		q.AddWithPriority(item, p)
+		return
+	}
+	time.AfterFunc(duration, func() {
+		q.AddWithPriority(item, p)
+	})
+}
+
+func (q *priorityQueue) AddRateLimited(item any) {
+	q.AddRateLimitedWithPriority(item, PriorityNormal)
+}
+
+func (q *priorityQueue) AddRateLimitedWithPriority(item any, p Priority) {
+	q.AddAfterWithPriority(item, q.rateLimiter.When(item), p)
+}
+
+func (q *priorityQueue) Forget(item any) {
+	q.rateLimiter.Forget(item)
+}
+
+func (q *priorityQueue) NumRequeues(item any) int {
+	return q.rateLimiter.NumRequeues(item)
+}
+
+func (q *priorityQueue) Len() int {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	return q.lenLocked()
+}
+
+func (q *priorityQueue) lenLocked() (result int) {
+	for _, c := range q.counts {
+		result += c
+	}
+	return
+}
+
+// Depth returns the number of queued keys with the given priority.
+func (q *priorityQueue) Depth(p Priority) int {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	return q.counts[q.level(p)]
+}
+
+func (q *priorityQueue) Get() (any, bool) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	for q.lenLocked() == 0 && !q.shuttingDown {
+		q.cond.Wait()
+	}
+	if q.shuttingDown {
+		return nil, true
+	}
+
+	p := q.nextLevelLocked()
+	for {
+		item := q.queues[p][0]
+		q.queues[p][0] = nil
+		q.queues[p] = q.queues[p][1:]
+
+		if existing, ok := q.queued[item]; !ok || existing != p {
+			// stale entry for an item that was moved to a higher priority or already processed
+			continue
+		}
+
+		delete(q.queued, item)
+		q.counts[p]--
+		q.depth[p].Set(float64(q.counts[p]))
+		q.processing[item] = p
+		return item, false
+	}
+}
+
+// nextLevelLocked returns the highest priority with queued keys, unless a lower priority has been passed over too many
+// times.
+func (q *priorityQueue) nextLevelLocked() Priority {
+	next := Priority(-1)
+	for p := PriorityLow; p < PriorityHigh; p++ {
+		if q.counts[p] > 0 && q.skipped[p] >= q.starvationLimit {
+			next = p
+			break
+		}
+	}
+	if next < 0 {
+		for p := PriorityHigh; p >= PriorityLow; p-- {
+			if q.counts[p] > 0 {
+				next = p
+				break
+			}
+		}
+	}
+
+	q.skipped[next] = 0
+	for p := PriorityLow; p < next; p++ {
+		if q.counts[p] > 0 {
+			q.skipped[p]++
+		}
+	}
+	return next
+}
+
+func (q *priorityQueue) Done(item any) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	delete(q.processing, item)
+	if p, ok := q.requeue[item]; ok {
+		delete(q.requeue, item)
+		q.addLocked(item, p)
+	}
+	if len(q.processing) == 0 {
+		// wake up ShutDownWithDrain
+		q.cond.Broadcast()
+	}
+}
+
+// Return gives a key returned by Get back to the queue with the priority it was queued with, without processing it.
+func (q *priorityQueue) Return(item any) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	p, ok := q.processing[item]
+	if !ok {
+		return
+	}
+	delete(q.processing, item)
+	if requeue, ok := q.requeue[item]; ok {
+		delete(q.requeue, item)
+		p = max(p, requeue)
+	}
+	q.addLocked(item, p)
+	if len(q.processing) == 0 {
+		// wake up ShutDownWithDrain
+		q.cond.Broadcast()
+	}
+}
+
+func (q *priorityQueue) ShutDown() {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	q.shuttingDown = true
+	q.cond.Broadcast()
+}
+
+// ShutDownWithDrain shuts down the queue and waits for the keys being processed to be done.
+func (q *priorityQueue) ShutDownWithDrain() {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	q.shuttingDown = true
+	q.cond.Broadcast()
+	for len(q.processing) > 0 {
+		q.cond.Wait()
+	}
+}
+
+func (q *priorityQueue) ShuttingDown() bool {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	return q.shuttingDown
+}
@@ -5,6 +5,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/acorn-io/baaah/pkg/log"
+	"github.com/acorn-io/baaah/pkg/metadata"
 	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -36,9 +38,14 @@ type sharedController struct {
 	handler            *SharedHandler
 	startLock          sync.Mutex
 	started            bool
+	ctx                context.Context
+	cancel             context.CancelFunc
 	startError         error
 	client             kclient.Client
 	gvk                schema.GroupVersionKind
+	metadataOnly       bool
+	// defaultWorkers is used when the controller is started with zero workers
+	defaultWorkers func() int
 }
 
 func (s *sharedController) Cache() (cache.Cache, error) {
@@ -57,6 +64,10 @@ func (s *sharedController) EnqueueKey(key string) {
 	s.initController().EnqueueKey(key)
 }
 
+func (s *sharedController) EnqueueKeyWithPriority(key string, priority Priority) {
+	s.initController().EnqueueKeyWithPriority(key, priority)
+}
+
 func (s *sharedController) initController() Controller {
 	s.startLock.Lock()
 	defer s.startLock.Unlock()
@@ -87,20 +98,93 @@ func (s *sharedController) Start(ctx context.Context, workers int) error {
 		return nil
 	}
 
+	if workers <= 0 && s.defaultWorkers != nil {
+		workers = s.defaultWorkers()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
 	if err := s.controller.Start(ctx, workers); err != nil {
+		cancel()
 		return err
 	}
 	s.started = true
+	s.ctx, s.cancel = ctx, cancel
 
 	context.AfterFunc(ctx, func() {
 		s.startLock.Lock()
 		defer s.startLock.Unlock()
-		s.started = false
+		// The controller may have been stopped and started again with a new context.
+		if s.ctx == ctx {
+			s.started = false
+		}
 	})
 
 	return nil
 }
 
+// drain stops the controller from processing new keys and waits for the keys being processed to be done.
+func (s *sharedController) drain(ctx context.Context) error {
+	s.startLock.Lock()
+	controller := s.controller
+	s.startLock.Unlock()
+
+	if d, ok := controller.(drainer); ok {
+		return d.drain(ctx)
+	}
+	return nil
+}
+
+// informer returns the informer of the controller if the controller exists, and the context of the controller if it is
+// running. Neither the controller nor the informer is created.
+func (s *sharedController) informer() (cache.Informer, context.Context) {
+	s.startLock.Lock()
+	defer s.startLock.Unlock()
+
+	g, ok := s.controller.(informerGetter)
+	if !ok {
+		return nil, nil
+	}
+	if !s.started {
+		return g.currentInformer(), nil
+	}
+	return g.currentInformer(), s.ctx
+}
+
+func (s *sharedController) SetWorkers(workers int) {
+	s.startLock.Lock()
+	defer s.startLock.Unlock()
+
+	if s.controller != nil {
+		s.controller.SetWorkers(workers)
+	}
+}
+
+// stopIfUnused stops the controller and removes its informer from the cache if no handlers are registered. The
+// controller is recreated the next time a handler is registered or a key is enqueued.
+func (s *sharedController) stopIfUnused() {
+	s.startLock.Lock()
+	defer s.startLock.Unlock()
+
+	if !s.started || !s.handler.empty() {
+		return
+	}
+
+	s.cancel()
+	s.ctx, s.cancel = nil, nil
+	s.started = false
+
+	if r, ok := s.controller.(informerRemover); ok {
+		if err := r.removeInformer(context.Background()); err != nil {
+			log.Errorf("failed to remove informer for %v: %v", s.gvk, err)
+		} else {
+			log.Infof("Stopped unused %v controller", s.gvk)
+		}
+	}
+
+	s.controller = nil
+	s.startError = nil
+}
+
 func (s *sharedController) RegisterHandler(ctx context.Context, name string, handler SharedControllerHandler) (returnErr error) {
 	// Ensure that controller is initialized
 	c := s.initController()
@@ -108,6 +192,8 @@ func (s *sharedController) RegisterHandler(ctx context.Context, name string, han
 	getHandlerTransaction(ctx).do(func() {
 		ctx, cancel := context.WithCancel(ctx)
 		s.handler.Register(ctx, name, handler)
+		// The controller may have been stopped while it had no handlers, so ensure it is initialized again.
+		c = s.initController()
 
 		defer func() {
 			if returnErr == nil {
@@ -125,11 +211,10 @@ func (s *sharedController) RegisterHandler(ctx context.Context, name string, han
 				cache   cache.Cache
 			)
 
-			objList, returnErr = s.client.Scheme().New(schema.GroupVersionKind{
-				Group:   s.gvk.Group,
-				Version: s.gvk.Version,
-				Kind:    s.gvk.Kind + "List",
-			})
+			objList, returnErr = s.newList()
+			if returnErr != nil {
+				return
+			}
 			cache, returnErr = s.controller.Cache()
 			if returnErr != nil {
 				return
@@ -139,7 +224,7 @@ func (s *sharedController) RegisterHandler(ctx context.Context, name string, han
 			}
 			returnErr = meta.EachListItem(objList, func(obj runtime.Object) error {
 				mObj := obj.(kclient.Object)
-				c.EnqueueKey(keyFunc(mObj.GetNamespace(), mObj.GetName()))
+				c.EnqueueKeyWithPriority(keyFunc(mObj.GetNamespace(), mObj.GetName()), PriorityLow)
 				return nil
 			})
 		}
@@ -147,3 +232,14 @@ func (s *sharedController) RegisterHandler(ctx context.Context, name string, han
 
 	return nil
 }
+
+func (s *sharedController) newList() (runtime.Object, error) {
+	if s.metadataOnly {
+		return metadata.NewPartialObjectList(s.gvk), nil
+	}
+	return s.client.Scheme().New(schema.GroupVersionKind{
+		Group:   s.gvk.Group,
+		Version: s.gvk.Version,
+		Kind:    s.gvk.Kind + "List",
+	})
+}
@@ -2,8 +2,11 @@ package runtime
 
 import (
 	"context"
+	"maps"
+	"slices"
 	"sync"
 
+	"github.com/acorn-io/baaah/pkg/merr"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/util/workqueue"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
@@ -12,30 +15,53 @@ import (
 
 type SharedControllerFactory interface {
 	ForKind(gvk schema.GroupVersionKind) (SharedController, error)
+	// ForMetadataKind returns a controller for the kind backed by a metadata-only informer.
+	ForMetadataKind(gvk schema.GroupVersionKind) (SharedController, error)
+	// SetWorkers changes the number of workers for the kind, including controllers that are already running.
+	SetWorkers(gvk schema.GroupVersionKind, workers int)
+	// Workers returns the number of workers for the kind.
+	Workers(gvk schema.GroupVersionKind) int
+	// Drain stops all controllers from processing new keys and waits until the keys being processed are done or the
+	// context is done.
+	Drain(ctx context.Context) error
+	// StartCache starts the cache with a context that may outlive the controllers. Otherwise, the cache is started by
+	// Start and stops with the controllers.
+	StartCache(ctx context.Context)
 	Start(ctx context.Context, workers int) error
 }
 
 type SharedControllerFactoryOptions struct {
 	DefaultRateLimiter workqueue.RateLimiter
 	DefaultWorkers     int
+	// DefaultPriorityQueue configures the order in which keys are processed.
+	DefaultPriorityQueue PriorityQueueOptions
 
-	KindRateLimiter map[schema.GroupVersionKind]workqueue.RateLimiter
-	KindWorkers     map[schema.GroupVersionKind]int
+	KindRateLimiter   map[schema.GroupVersionKind]workqueue.RateLimiter
+	KindWorkers       map[schema.GroupVersionKind]int
+	KindPriorityQueue map[schema.GroupVersionKind]PriorityQueueOptions
+}
+
+type controllerKey struct {
+	gvk          schema.GroupVersionKind
+	metadataOnly bool
 }
 
 type sharedControllerFactory struct {
 	controllerLock sync.RWMutex
 	cacheStartLock sync.Mutex
+	workersLock    sync.RWMutex
 
 	cache        cache.Cache
 	cacheStarted bool
 	client       kclient.Client
-	controllers  map[schema.GroupVersionKind]*sharedController
+	controllers  map[controllerKey]*sharedController
 
 	rateLimiter     workqueue.RateLimiter
 	workers         int
 	kindRateLimiter map[schema.GroupVersionKind]workqueue.RateLimiter
 	kindWorkers     map[schema.GroupVersionKind]int
+	priorityQueue   PriorityQueueOptions
+	kindPriority    map[schema.GroupVersionKind]PriorityQueueOptions
 }
 
 func NewSharedControllerFactory(c kclient.Client, cache cache.Cache, opts *SharedControllerFactoryOptions) SharedControllerFactory {
@@ -43,11 +69,13 @@ func NewSharedControllerFactory(c kclient.Client, cache cache.Cache, opts *Share
 	return &sharedControllerFactory{
 		cache:           cache,
 		client:          c,
-		controllers:     map[schema.GroupVersionKind]*sharedController{},
+		controllers:     map[controllerKey]*sharedController{},
 		workers:         opts.DefaultWorkers,
-		kindWorkers:     opts.KindWorkers,
+		kindWorkers:     maps.Clone(opts.KindWorkers),
 		rateLimiter:     opts.DefaultRateLimiter,
 		kindRateLimiter: opts.KindRateLimiter,
+		priorityQueue:   opts.DefaultPriorityQueue,
+		kindPriority:    opts.KindPriorityQueue,
 	}
 }
 
@@ -57,7 +85,7 @@ func applyDefaultSharedOptions(opts *SharedControllerFactoryOptions) *SharedCont
 		newOpts = *opts
 	}
 	if newOpts.DefaultWorkers == 0 {
-		newOpts.DefaultWorkers = 5
+		newOpts.DefaultWorkers = DefaultThreadiness
 	}
 	return &newOpts
 }
@@ -66,20 +94,10 @@ func (s *sharedControllerFactory) Start(ctx context.Context, defaultWorkers int)
 	s.controllerLock.Lock()
 	defer s.controllerLock.Unlock()
 
-	go func() {
-		s.cacheStartLock.Lock()
-		defer s.cacheStartLock.Unlock()
-		if s.cacheStarted {
-			return
-		}
-		if err := s.cache.Start(ctx); err != nil {
-			panic(err)
-		}
-		s.cacheStarted = true
-	}()
+	s.StartCache(ctx)
 
 	// copy so we can release the lock during cache wait
-	controllersCopy := map[schema.GroupVersionKind]*sharedController{}
+	controllersCopy := map[controllerKey]*sharedController{}
 	for k, v := range s.controllers {
 		controllersCopy[k] = v
 	}
@@ -91,8 +109,8 @@ func (s *sharedControllerFactory) Start(ctx context.Context, defaultWorkers int)
 	s.cache.WaitForCacheSync(ctx)
 	s.controllerLock.Lock()
 
-	for gvk, controller := range controllersCopy {
-		w, err := s.getWorkers(gvk, defaultWorkers)
+	for key, controller := range controllersCopy {
+		w, err := s.getWorkers(key.gvk, defaultWorkers)
 		if err != nil {
 			return err
 		}
@@ -104,8 +122,58 @@ func (s *sharedControllerFactory) Start(ctx context.Context, defaultWorkers int)
 	return nil
 }
 
+func (s *sharedControllerFactory) StartCache(ctx context.Context) {
+	s.cacheStartLock.Lock()
+	defer s.cacheStartLock.Unlock()
+	if s.cacheStarted {
+		return
+	}
+	s.cacheStarted = true
+
+	go func() {
+		if err := s.cache.Start(ctx); err != nil {
+			panic(err)
+		}
+	}()
+}
+
+func (s *sharedControllerFactory) Drain(ctx context.Context) error {
+	s.controllerLock.RLock()
+	controllers := slices.Collect(maps.Values(s.controllers))
+	s.controllerLock.RUnlock()
+
+	var (
+		wg   sync.WaitGroup
+		lock sync.Mutex
+		errs []error
+	)
+	for _, c := range controllers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.drain(ctx); err != nil {
+				lock.Lock()
+				errs = append(errs, err)
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return merr.NewErrors(errs...)
+}
+
 func (s *sharedControllerFactory) ForKind(gvk schema.GroupVersionKind) (SharedController, error) {
-	controllerResult := s.byGVK(gvk)
+	return s.forKind(controllerKey{gvk: gvk})
+}
+
+func (s *sharedControllerFactory) ForMetadataKind(gvk schema.GroupVersionKind) (SharedController, error) {
+	return s.forKind(controllerKey{gvk: gvk, metadataOnly: true})
+}
+
+func (s *sharedControllerFactory) forKind(key controllerKey) (SharedController, error) {
+	gvk := key.gvk
+	controllerResult := s.byKey(key)
 	if controllerResult != nil {
 		return controllerResult, nil
 	}
@@ -113,7 +181,7 @@ func (s *sharedControllerFactory) ForKind(gvk schema.GroupVersionKind) (SharedCo
 	s.controllerLock.Lock()
 	defer s.controllerLock.Unlock()
 
-	controllerResult = s.controllers[gvk]
+	controllerResult = s.controllers[key]
 	if controllerResult != nil {
 		return controllerResult, nil
 	}
@@ -126,21 +194,55 @@ func (s *sharedControllerFactory) ForKind(gvk schema.GroupVersionKind) (SharedCo
 			if !ok {
 				rateLimiter = s.rateLimiter
 			}
+			priorityQueue, ok := s.kindPriority[gvk]
+			if !ok {
+				priorityQueue = s.priorityQueue
+			}
 
 			return New(gvk, s.client.Scheme(), s.cache, handler, &Options{
-				RateLimiter: rateLimiter,
+				RateLimiter:   rateLimiter,
+				MetadataOnly:  key.metadataOnly,
+				PriorityQueue: priorityQueue,
 			})
 		},
-		handler: handler,
-		client:  s.client,
-		gvk:     gvk,
+		handler:      handler,
+		client:       s.client,
+		gvk:          gvk,
+		metadataOnly: key.metadataOnly,
+		defaultWorkers: func() int {
+			w, _ := s.getWorkers(gvk, 0)
+			return w
+		},
 	}
 
-	s.controllers[gvk] = controllerResult
+	handler.onEmpty = controllerResult.stopIfUnused
+	s.controllers[key] = controllerResult
 	return controllerResult, nil
 }
 
+func (s *sharedControllerFactory) SetWorkers(gvk schema.GroupVersionKind, workers int) {
+	s.workersLock.Lock()
+	if s.kindWorkers == nil {
+		s.kindWorkers = map[schema.GroupVersionKind]int{}
+	}
+	s.kindWorkers[gvk] = workers
+	s.workersLock.Unlock()
+
+	for _, metadataOnly := range []bool{false, true} {
+		if c := s.byKey(controllerKey{gvk: gvk, metadataOnly: metadataOnly}); c != nil {
+			c.SetWorkers(workers)
+		}
+	}
+}
+
+func (s *sharedControllerFactory) Workers(gvk schema.GroupVersionKind) int {
+	w, _ := s.getWorkers(gvk, 0)
+	return w
+}
+
 func (s *sharedControllerFactory) getWorkers(gvk schema.GroupVersionKind, workers int) (int, error) {
+	s.workersLock.RLock()
+	defer s.workersLock.RUnlock()
 	w, ok := s.kindWorkers[gvk]
 	if ok {
 		return w, nil
@@ -151,8 +253,26 @@ func (s *sharedControllerFactory) getWorkers(gvk schema.GroupVersionKind, worker
 	return s.workers, nil
 }
 
-func (s *sharedControllerFactory) byGVK(gvk schema.GroupVersionKind) *sharedController {
+type informerLookup interface {
+	existingInformer(gvk schema.GroupVersionKind, metadataOnly bool) (cache.Informer, context.Context)
+}
+
+// existingInformer returns the informer of the controller for the kind, preferring the metadata-only controller if
+// metadataOnly is set. The returned context is done when the controller stops and is nil if the controller is not
+// running. Nil is returned if there is no controller for the kind; nothing is created.
+func (s *sharedControllerFactory) existingInformer(gvk schema.GroupVersionKind, metadataOnly bool) (cache.Informer, context.Context) {
+	for _, m := range []bool{metadataOnly, !metadataOnly} {
+		if c := s.byKey(controllerKey{gvk: gvk, metadataOnly: m}); c != nil {
+			if informer, ctx := c.informer(); informer != nil {
+				return informer, ctx
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (s *sharedControllerFactory) byKey(key controllerKey) *sharedController {
 	s.controllerLock.RLock()
 	defer s.controllerLock.RUnlock()
-	return s.controllers[gvk]
+	return s.controllers[key]
 }
@@ -9,6 +9,7 @@ import (
 	"sync"
 	"sync/atomic"
 
+	"github.com/acorn-io/baaah/pkg/backend"
 	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
 )
@@ -29,6 +30,8 @@ type SharedHandler struct {
 
 	lock     sync.RWMutex
 	handlers []handlerEntry
+	// onEmpty is called when the last registered handler is removed because its kind is no longer watched.
+	onEmpty func()
 }
 
 func (h *SharedHandler) Register(ctx context.Context, name string, handler SharedControllerHandler) {
@@ -44,17 +47,28 @@ func (h *SharedHandler) Register(ctx context.Context, name string, handler Share
 
 	context.AfterFunc(ctx, func() {
 		h.lock.Lock()
-		defer h.lock.Unlock()
-
 		for i := range h.handlers {
 			if h.handlers[i].id == id {
 				h.handlers = append(h.handlers[:i], h.handlers[i+1:]...)
 				break
 			}
 		}
+		empty := len(h.handlers) == 0
+		h.lock.Unlock()
+
+		// Handlers removed for other reasons, such as losing leadership, keep the informer so that the cache stays warm.
+		if empty && h.onEmpty != nil && errors.Is(context.Cause(ctx), backend.ErrUnwatched) {
+			h.onEmpty()
+		}
 	})
 }
 
+func (h *SharedHandler) empty() bool {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return len(h.handlers) == 0
+}
+
 func (h *SharedHandler) OnChange(key string, obj runtime.Object) error {
 	var (
 		errs errorList
@@ -118,6 +132,10 @@ func (e errorList) Cause() error {
 	return nil
 }
 
+func (e errorList) Unwrap() []error {
+	return e
+}
+
 type handlerError struct {
 	HandlerName string
 	Err         error
@@ -130,3 +148,7 @@ func (h handlerError) Error() string {
 func (h handlerError) Cause() error {
 	return h.Err
 }
+
+func (h handlerError) Unwrap() error {
+	return h.Err
+}
@@ -0,0 +1,51 @@
+package runtime
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	kcache "k8s.io/client-go/tools/cache"
+)
+
+// StripManagedFields is a cache transform that removes the managedFields from objects before they are cached.
+func StripManagedFields(obj any) (any, error) {
+	if m, err := meta.Accessor(obj); err == nil {
+		m.SetManagedFields(nil)
+	}
+	return obj, nil
+}
+
+// StripAnnotations returns a cache transform that removes the given annotations from objects before they are cached.
+// This is useful for large annotations such as kubectl.kubernetes.io/last-applied-configuration.
+func StripAnnotations(keys ...string) kcache.TransformFunc {
+	return func(obj any) (any, error) {
+		m, err := meta.Accessor(obj)
+		if err != nil {
+			return obj, nil
+		}
+		annotations := m.GetAnnotations()
+		if len(annotations) == 0 {
+			return obj, nil
+		}
+		for _, key := range keys {
+			delete(annotations, key)
+		}
+		m.SetAnnotations(annotations)
+		return obj, nil
+	}
+}
+
+// ChainTransforms returns a cache transform that applies each of the given transforms in order.
+func ChainTransforms(transforms ...kcache.TransformFunc) kcache.TransformFunc {
+	return func(obj any) (any, error) {
+		var err error
+		for _, t := range transforms {
+			if t == nil {
+				continue
+			}
+			obj, err = t(obj)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return obj, nil
+	}
+}
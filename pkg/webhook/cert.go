@@ -0,0 +1,221 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"slices"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// CACertKey is the key of the PEM encoded CA bundle in the Secret of the webhook server.
+	CACertKey = "ca.crt"
+	caKeyKey  = "ca.key"
+
+	caValidity      = 10 * 365 * 24 * time.Hour
+	servingValidity = 365 * 24 * time.Hour
+)
+
+// ensureCerts generates the CA and serving certificate in the Secret if they are missing, invalid, or due to be
+// rotated. Returns true if the Secret was changed.
+func ensureCerts(secret *corev1.Secret, commonName string, dnsNames []string, now time.Time) (bool, error) {
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+
+	ca, caKey, err := parseCA(secret.Data)
+	caChanged := err != nil || needsRotation(ca, now)
+	if caChanged {
+		ca, caKey, err = newCA(commonName, now)
+		if err != nil {
+			return false, err
+		}
+		keyPEM, err := encodeKey(caKey)
+		if err != nil {
+			return false, err
+		}
+		// Keep trusting the previous CA until it expires so that servers still using the previous serving
+		// certificate are trusted while the Secret is reloaded.
+		secret.Data[CACertKey] = append(encodeCert(ca), validCerts(secret.Data[CACertKey], now)...)
+		secret.Data[caKeyKey] = keyPEM
+	}
+
+	serving, err := parseServing(secret.Data, ca)
+	if !caChanged && err == nil && !needsRotation(serving, now) && slices.Equal(serving.DNSNames, dnsNames) {
+		return false, nil
+	}
+
+	certPEM, keyPEM, err := newServingCert(ca, caKey, dnsNames, now)
+	if err != nil {
+		return false, err
+	}
+	secret.Type = corev1.SecretTypeTLS
+	secret.Data[corev1.TLSCertKey] = certPEM
+	secret.Data[corev1.TLSPrivateKeyKey] = keyPEM
+	return true, nil
+}
+
+// nextRotation returns when the certificates in the Secret are due to be rotated.
+func nextRotation(secret *corev1.Secret) time.Time {
+	ca, _, err := parseCA(secret.Data)
+	if err != nil {
+		return time.Now()
+	}
+	serving, err := parseServing(secret.Data, ca)
+	if err != nil {
+		return time.Now()
+	}
+	return minTime(rotationTime(ca), rotationTime(serving))
+}
+
+// rotationTime returns the time after which two thirds of the validity of the certificate have passed.
+func rotationTime(cert *x509.Certificate) time.Time {
+	return cert.NotBefore.Add(cert.NotAfter.Sub(cert.NotBefore) * 2 / 3)
+}
+
+func needsRotation(cert *x509.Certificate, now time.Time) bool {
+	return !now.Before(rotationTime(cert))
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func parseCA(data map[string][]byte) (*x509.Certificate, crypto.Signer, error) {
+	block, _ := pem.Decode(data[CACertKey])
+	if block == nil {
+		return nil, nil, fmt.Errorf("missing %s", CACertKey)
+	}
+	ca, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ = pem.Decode(data[caKeyKey])
+	if block == nil {
+		return nil, nil, fmt.Errorf("missing %s", caKeyKey)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s is not a signing key", caKeyKey)
+	}
+	return ca, signer, nil
+}
+
+func parseServing(data map[string][]byte, ca *x509.Certificate) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data[corev1.TLSCertKey])
+	if block == nil {
+		return nil, fmt.Errorf("missing %s", corev1.TLSCertKey)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := cert.CheckSignatureFrom(ca); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// validCerts returns the PEM encoded certificates in the bundle that have not expired.
+func validCerts(bundle []byte, now time.Time) []byte {
+	var result bytes.Buffer
+	for {
+		var block *pem.Block
+		block, bundle = pem.Decode(bundle)
+		if block == nil {
+			return result.Bytes()
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err == nil && now.Before(cert.NotAfter) {
+			_ = pem.Encode(&result, block)
+		}
+	}
+}
+
+func newCA(commonName string, now time.Time) (*x509.Certificate, crypto.Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName + "-ca"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+	ca, err := x509.ParseCertificate(der)
+	return ca, key, err
+}
+
+func newServingCert(ca *x509.Certificate, caKey crypto.Signer, dnsNames []string, now time.Time) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     minTime(now.Add(servingValidity), ca.NotAfter),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, key.Public(), caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), keyPEM, nil
+}
+
+func newSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func encodeCert(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
+
+func encodeKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
@@ -0,0 +1,144 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/acorn-io/baaah/pkg/log"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+)
+
+// Converter serves apiextensions.k8s.io/v1 ConversionReviews for custom resources with more than one version. Objects
+// are converted with the hub and spoke interfaces of controller-runtime if the types implement them, and otherwise with
+// the conversion funcs registered in the scheme.
+type Converter struct {
+	scheme *runtime.Scheme
+}
+
+func NewConverter(scheme *runtime.Scheme) *Converter {
+	return &Converter{
+		scheme: scheme,
+	}
+}
+
+func (c *Converter) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	review := &apiextensionsv1.ConversionReview{}
+	if err := json.NewDecoder(req.Body).Decode(review); err != nil {
+		log.Errorf("failed to decode conversion review: %v", err)
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if review.Request == nil {
+		http.Error(rw, "request is not set", http.StatusBadRequest)
+		return
+	}
+
+	review.Response = c.Convert(review.Request)
+	review.Request = nil
+	rw.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(rw).Encode(review)
+}
+
+// Convert converts the objects of the request to the desired version. If any object fails to convert, the response
+// has a failure status and no objects.
+func (c *Converter) Convert(req *apiextensionsv1.ConversionRequest) *apiextensionsv1.ConversionResponse {
+	resp := &apiextensionsv1.ConversionResponse{
+		UID: req.UID,
+	}
+
+	for _, obj := range req.Objects {
+		converted, err := c.convert(obj.Raw, req.DesiredAPIVersion)
+		if err != nil {
+			log.Errorf("failed to convert to %s: %v", req.DesiredAPIVersion, err)
+			resp.ConvertedObjects = nil
+			resp.Result = metav1.Status{
+				Status:  metav1.StatusFailure,
+				Message: err.Error(),
+			}
+			return resp
+		}
+		resp.ConvertedObjects = append(resp.ConvertedObjects, runtime.RawExtension{Raw: converted})
+	}
+
+	resp.Result = metav1.Status{
+		Status: metav1.StatusSuccess,
+	}
+	return resp
+}
+
+func (c *Converter) convert(raw []byte, desiredAPIVersion string) ([]byte, error) {
+	typeMeta := metav1.TypeMeta{}
+	if err := json.Unmarshal(raw, &typeMeta); err != nil {
+		return nil, err
+	}
+	srcGVK := typeMeta.GroupVersionKind()
+	dstGVK := schema.FromAPIVersionAndKind(desiredAPIVersion, srcGVK.Kind)
+	if srcGVK == dstGVK {
+		return raw, nil
+	}
+	if srcGVK.GroupKind() != dstGVK.GroupKind() {
+		return nil, fmt.Errorf("cannot convert %v to a different group %s", srcGVK, dstGVK.Group)
+	}
+
+	src, err := c.scheme.New(srcGVK)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, src); err != nil {
+		return nil, err
+	}
+	dst, err := c.scheme.New(dstGVK)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.convertObject(src, dst, srcGVK.GroupKind()); err != nil {
+		return nil, fmt.Errorf("failed to convert %v to %v: %w", srcGVK, dstGVK, err)
+	}
+	dst.GetObjectKind().SetGroupVersionKind(dstGVK)
+	return json.Marshal(dst)
+}
+
+func (c *Converter) convertObject(src, dst runtime.Object, gk schema.GroupKind) error {
+	srcHub, srcIsHub := src.(conversion.Hub)
+	dstHub, dstIsHub := dst.(conversion.Hub)
+	srcSpoke, srcIsSpoke := src.(conversion.Convertible)
+	dstSpoke, dstIsSpoke := dst.(conversion.Convertible)
+
+	switch {
+	case srcIsHub && dstIsSpoke:
+		return dstSpoke.ConvertFrom(srcHub)
+	case srcIsSpoke && dstIsHub:
+		return srcSpoke.ConvertTo(dstHub)
+	case srcIsSpoke && dstIsSpoke:
+		hub, err := c.hubFor(gk)
+		if err != nil {
+			return err
+		}
+		if err := srcSpoke.ConvertTo(hub); err != nil {
+			return err
+		}
+		return dstSpoke.ConvertFrom(hub)
+	default:
+		return c.scheme.Convert(src, dst, nil)
+	}
+}
+
+// hubFor returns a new object of the version of the kind that is the hub.
+func (c *Converter) hubFor(gk schema.GroupKind) (conversion.Hub, error) {
+	for _, version := range c.scheme.VersionsForGroupKind(gk) {
+		obj, err := c.scheme.New(version.WithKind(gk.Kind))
+		if err != nil {
+			continue
+		}
+		if hub, ok := obj.(conversion.Hub); ok {
+			return hub, nil
+		}
+	}
+	return nil, fmt.Errorf("no hub version found for %v", gk)
+}
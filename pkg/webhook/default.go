@@ -0,0 +1,149 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Default returns a mutating handler that sets the defaults of the object of the request with the defaulting funcs
+// registered in the scheme and then with the `default` struct tags of its fields. The value of a tag is only set on a
+// field that is empty. It is parsed as a string, number, bool, or duration for fields of those types, and as JSON
+// otherwise.
+func Default(scheme *runtime.Scheme) Handler {
+	return HandlerFunc(func(resp *Response, req *Request) error {
+		resp.Allowed = true
+		if len(req.Object.Raw) == 0 {
+			return nil
+		}
+
+		obj, err := scheme.New(schema.GroupVersionKind(req.Kind))
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(req.Object.Raw, obj); err != nil {
+			return err
+		}
+
+		scheme.Default(obj)
+		if err := SetDefaults(obj); err != nil {
+			return err
+		}
+
+		newObj, ok := obj.(kclient.Object)
+		if !ok {
+			return fmt.Errorf("%T is not an object", obj)
+		}
+		return resp.CreatePatch(req, newObj)
+	})
+}
+
+// SetDefaults sets the empty fields of obj, which must be a pointer, to the values of their `default` struct tags.
+// Nested structs are defaulted as well, including those in slices and maps.
+func SetDefaults(obj any) error {
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return fmt.Errorf("cannot set defaults of %T, it must be a pointer", obj)
+	}
+	return setDefaults(v.Elem())
+}
+
+func setDefaults(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			return setDefaults(v.Elem())
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := setDefaults(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			// Map values are not addressable, so default a copy and store it.
+			value := reflect.New(v.Type().Elem()).Elem()
+			value.Set(v.MapIndex(key))
+			if err := setDefaults(value); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, value)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			if def, ok := field.Tag.Lookup("default"); ok && v.Field(i).IsZero() {
+				if err := setDefault(v.Field(i), def); err != nil {
+					return fmt.Errorf("invalid default of field %s.%s: %w", t.Name(), field.Name, err)
+				}
+			}
+			if err := setDefaults(v.Field(i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func setDefault(v reflect.Value, def string) error {
+	if v.Kind() == reflect.Pointer {
+		value := reflect.New(v.Type().Elem())
+		if err := setDefault(value.Elem(), def); err != nil {
+			return err
+		}
+		v.Set(value)
+		return nil
+	}
+
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(def)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(def)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(def)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case v.CanInt():
+		i, err := strconv.ParseInt(def, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case v.CanUint():
+		u, err := strconv.ParseUint(def, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+	case v.CanFloat():
+		f, err := strconv.ParseFloat(def, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return json.NewDecoder(strings.NewReader(def)).Decode(v.Addr().Interface())
+	}
+	return nil
+}
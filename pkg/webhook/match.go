@@ -1,11 +1,27 @@
 package webhook
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
 	v1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/utils/ptr"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 type RouteMatch struct {
-	handler     Handler
+	handlers    []Handler
 	kind        string
 	resource    string
 	version     string
@@ -15,12 +31,38 @@ type RouteMatch struct {
 	name        string
 	namespace   string
 	operation   v1.Operation
+	mutating    bool
+	middleware  []Middleware
+	selector    labels.Selector
+	nsSelector  labels.Selector
 }
 
-func (r *RouteMatch) admit(response *Response, request *Request) error {
-	if r.handler != nil {
-		return r.handler.Admit(response, request)
+// admit runs the handlers of the route in order until one does not allow the request. The patch of each handler is
+// applied to the object of the request, so that it is seen by the next handler.
+func (r *RouteMatch) admit(response *Response, request *Request, middleware []Middleware) error {
+	middleware = append(slices.Clip(middleware), r.middleware...)
+	for _, h := range r.handlers {
+		for i := len(middleware) - 1; i >= 0; i-- {
+			h = middleware[i](h)
+		}
+
+		response.Allowed = false
+		response.Patch, response.PatchType = nil, nil
+		if err := h.Admit(response, request); err != nil || !response.Allowed {
+			return err
+		}
+		if len(response.Patch) == 0 {
+			continue
+		}
+		patch, err := jsonpatch.DecodePatch(response.Patch)
+		if err != nil {
+			return err
+		}
+		if request.Object.Raw, err = patch.Apply(request.Object.Raw); err != nil {
+			return err
+		}
 	}
+	response.Patch, response.PatchType = nil, nil
 	return nil
 }
 
@@ -46,6 +88,104 @@ func (r *RouteMatch) matches(req *v1.AdmissionRequest) bool {
 		checkBool(r.dryRun, req.DryRun)
 }
 
+// matchesSelectors returns true if the labels of the object and its namespace match the selectors of the route. As
+// in the API server, the object selector matches if either the new or the old object matches, and the namespace
+// selector matches the labels of a Namespace itself and all cluster scoped objects.
+func (r *RouteMatch) matchesSelectors(ctx context.Context, client kclient.Reader, req *v1.AdmissionRequest) (bool, error) {
+	if r.selector == nil && r.nsSelector == nil {
+		return true, nil
+	}
+
+	var objs []*metav1.PartialObjectMetadata
+	for _, raw := range [][]byte{req.Object.Raw, req.OldObject.Raw} {
+		if len(raw) == 0 {
+			continue
+		}
+		obj := &metav1.PartialObjectMetadata{}
+		if err := json.Unmarshal(raw, obj); err != nil {
+			return false, err
+		}
+		objs = append(objs, obj)
+	}
+
+	if r.selector != nil && !slices.ContainsFunc(objs, func(obj *metav1.PartialObjectMetadata) bool {
+		return r.selector.Matches(labels.Set(obj.Labels))
+	}) {
+		return false, nil
+	}
+	if r.nsSelector == nil {
+		return true, nil
+	}
+
+	if req.Kind.Group == "" && req.Kind.Kind == "Namespace" {
+		// The new object is used, or the old object for a delete.
+		var nsLabels labels.Set
+		if len(objs) > 0 {
+			nsLabels = objs[0].Labels
+		}
+		return r.nsSelector.Matches(nsLabels), nil
+	}
+	if req.Namespace == "" {
+		return true, nil
+	}
+	if client == nil {
+		return false, fmt.Errorf("a client is required to match the namespace selector of a route")
+	}
+
+	ns := &corev1.Namespace{}
+	if err := client.Get(ctx, kclient.ObjectKey{Name: req.Namespace}, ns); apierrors.IsNotFound(err) {
+		// The namespace may not be in the cache yet, so match it as if it has no labels.
+		return r.nsSelector.Matches(labels.Set{}), nil
+	} else if err != nil {
+		return false, err
+	}
+	return r.nsSelector.Matches(labels.Set(ns.Labels)), nil
+}
+
+// rule returns the rule of a webhook configuration that sends the requests matched by this route to the webhook. The
+// kind is mapped to its resource if the resource is not set.
+func (r *RouteMatch) rule(mapper meta.RESTMapper) (admissionregistrationv1.RuleWithOperations, error) {
+	group, version, resource := r.group, r.version, r.resource
+	if resource == "" && r.kind != "" {
+		var versions []string
+		if version != "" {
+			versions = append(versions, version)
+		}
+		mapping, err := mapper.RESTMapping(schema.GroupKind{Group: group, Kind: r.kind}, versions...)
+		if err != nil {
+			return admissionregistrationv1.RuleWithOperations{}, err
+		}
+		group, resource = mapping.Resource.Group, mapping.Resource.Resource
+	}
+
+	resources := []string{wildcard(resource) + "/" + r.subResource}
+	if r.subResource == "" {
+		resources = []string{wildcard(resource), wildcard(resource) + "/*"}
+	}
+
+	operation := admissionregistrationv1.OperationAll
+	if r.operation != "" {
+		operation = admissionregistrationv1.OperationType(r.operation)
+	}
+
+	return admissionregistrationv1.RuleWithOperations{
+		Operations: []admissionregistrationv1.OperationType{operation},
+		Rule: admissionregistrationv1.Rule{
+			APIGroups:   []string{wildcard(group)},
+			APIVersions: []string{wildcard(version)},
+			Resources:   resources,
+			Scope:       ptr.To(admissionregistrationv1.AllScopes),
+		},
+	}, nil
+}
+
+func wildcard(s string) string {
+	if s == "" {
+		return "*"
+	}
+	return s
+}
+
 func checkString(expected, actual string) bool {
 	if expected == "" {
 		return true
@@ -67,15 +207,30 @@ func checkBool(expected, actual *bool) bool {
 
 func (r *RouteMatch) DryRun(dryRun bool) *RouteMatch               { r.dryRun = &dryRun; return r }
 func (r *RouteMatch) Group(group string) *RouteMatch               { r.group = group; return r }
-func (r *RouteMatch) HandleFunc(handler HandlerFunc)               { r.handler = handler }
-func (r *RouteMatch) Handle(handler Handler)                       { r.handler = handler }
+func (r *RouteMatch) HandleFunc(handler HandlerFunc)               { r.Handle(handler) }
+func (r *RouteMatch) Handle(handler Handler)                       { r.handlers = append(r.handlers, handler) }
 func (r *RouteMatch) Kind(kind string) *RouteMatch                 { r.kind = kind; return r }
+func (r *RouteMatch) Mutating() *RouteMatch                        { r.mutating = true; return r }
 func (r *RouteMatch) Name(name string) *RouteMatch                 { r.name = name; return r }
 func (r *RouteMatch) Namespace(namespace string) *RouteMatch       { r.namespace = namespace; return r }
 func (r *RouteMatch) Operation(operation v1.Operation) *RouteMatch { r.operation = operation; return r }
 func (r *RouteMatch) Resource(resource string) *RouteMatch         { r.resource = resource; return r }
+func (r *RouteMatch) Selector(sel labels.Selector) *RouteMatch     { r.selector = sel; return r }
 func (r *RouteMatch) SubResource(sr string) *RouteMatch            { r.subResource = sr; return r }
+func (r *RouteMatch) Then(handler Handler) *RouteMatch             { r.Handle(handler); return r }
 func (r *RouteMatch) Version(version string) *RouteMatch           { r.version = version; return r }
+func (r *RouteMatch) Defaults(scheme *runtime.Scheme) *RouteMatch {
+	r.mutating = true
+	return r.Then(Default(scheme))
+}
+func (r *RouteMatch) Middleware(m ...Middleware) *RouteMatch {
+	r.middleware = append(r.middleware, m...)
+	return r
+}
+func (r *RouteMatch) NamespaceSelector(sel labels.Selector) *RouteMatch {
+	r.nsSelector = sel
+	return r
+}
 
 // Wrappers for pretty methods
 
@@ -84,11 +239,17 @@ func (r *Router) Group(group string) *RouteMatch               { return r.next()
 func (r *Router) HandleFunc(hf HandlerFunc)                    { r.next().HandleFunc(hf) }
 func (r *Router) Handle(handler Handler)                       { r.next().Handle(handler) }
 func (r *Router) Kind(kind string) *RouteMatch                 { return r.next().Kind(kind) }
+func (r *Router) Middleware(m ...Middleware) *RouteMatch       { return r.next().Middleware(m...) }
+func (r *Router) Mutating() *RouteMatch                        { return r.next().Mutating() }
 func (r *Router) Name(name string) *RouteMatch                 { return r.next().Name(name) }
 func (r *Router) Namespace(namespace string) *RouteMatch       { return r.next().Namespace(namespace) }
 func (r *Router) Operation(operation v1.Operation) *RouteMatch { return r.next().Operation(operation) }
 func (r *Router) Resource(resource string) *RouteMatch         { return r.next().Resource(resource) }
+func (r *Router) Selector(sel labels.Selector) *RouteMatch     { return r.next().Selector(sel) }
 func (r *Router) SubResource(subResource string) *RouteMatch {
 	return r.next().SubResource(subResource)
 }
 func (r *Router) Version(version string) *RouteMatch { return r.next().Version(version) }
+func (r *Router) NamespaceSelector(sel labels.Selector) *RouteMatch {
+	return r.next().NamespaceSelector(sel)
+}
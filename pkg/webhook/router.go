@@ -1,15 +1,22 @@
 package webhook
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
 
 	"github.com/acorn-io/baaah/pkg/log"
+	"github.com/acorn-io/baaah/pkg/merr"
 	"gomodules.xyz/jsonpatch/v2"
 	v1 "k8s.io/api/admission/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/utils/ptr"
 	kclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -22,35 +29,83 @@ func NewRouter() *Router {
 }
 
 type Router struct {
-	matches []*RouteMatch
+	matches      []*RouteMatch
+	middleware   []Middleware
+	defaultAllow *bool
+	matchAll     bool
+	client       kclient.Reader
 }
 
-func (r *Router) sendError(rw http.ResponseWriter, review *v1.AdmissionReview, err error) {
+// SetClient sets the client used to get the labels of namespaces for routes with a namespace selector. A cached client
+// should be used. The webhook Server sets the client of the baaah Router if none is set.
+func (r *Router) SetClient(client kclient.Reader) {
+	r.client = client
+}
+
+// SetDefaultAllow sets whether requests that match no route are allowed. Otherwise, they fail with an internal error.
+// Unless set, requests that match no route fail, or are allowed if the Router is served by a webhook Server.
+func (r *Router) SetDefaultAllow(allow bool) {
+	r.defaultAllow = &allow
+}
+
+// SetMatchAll sets whether requests are admitted by every matching route instead of only the first. The request is
+// allowed only if all routes allow it, the warnings and denials of all routes are combined, and the patch of each
+// route is applied to the object seen by the next.
+func (r *Router) SetMatchAll(matchAll bool) {
+	r.matchAll = matchAll
+}
+
+// Use adds middleware to the handlers of all routes. It wraps the middleware of the routes.
+func (r *Router) Use(m ...Middleware) {
+	r.middleware = append(r.middleware, m...)
+}
+
+// sendError fails the request with an internal error, so that the failure policy of the webhook applies. Denials are
+// returned in the response by admit instead.
+func (r *Router) sendError(rw http.ResponseWriter, review *v1.AdmissionReview, gv schema.GroupVersion, err error) {
 	log.Errorf("%v", err)
 	if review == nil || review.Request == nil {
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	review.Response.Allowed = false
-	review.Response.Result = &errors.NewInternalError(err).ErrStatus
-	writeResponse(rw, review)
+	review.Response.Result = ptr.To(apierrors.NewInternalError(err).Status())
+	writeResponse(rw, review, gv)
 }
 
-func writeResponse(rw http.ResponseWriter, review *v1.AdmissionReview) {
-	rw.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(rw).Encode(review)
+func (r *Router) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	r.serve(rw, req, func(*RouteMatch) bool { return true })
 }
 
-func (r *Router) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	review := &v1.AdmissionReview{}
-	err := json.NewDecoder(req.Body).Decode(review)
+// ValidatingHandler returns a handler for the routes that are not mutating.
+func (r *Router) ValidatingHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		r.serve(rw, req, func(m *RouteMatch) bool { return !m.mutating })
+	})
+}
+
+// MutatingHandler returns a handler for the routes marked as mutating.
+func (r *Router) MutatingHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		r.serve(rw, req, func(m *RouteMatch) bool { return m.mutating })
+	})
+}
+
+func (r *Router) serve(rw http.ResponseWriter, req *http.Request, filter func(*RouteMatch) bool) {
+	data, err := io.ReadAll(req.Body)
+	if err != nil {
+		r.sendError(rw, nil, v1.SchemeGroupVersion, err)
+		return
+	}
+
+	review, gv, err := decodeReview(data)
 	if err != nil {
-		r.sendError(rw, review, err)
+		r.sendError(rw, review, gv, err)
 		return
 	}
 
 	if review.Request == nil {
-		r.sendError(rw, review, fmt.Errorf("request is not set"))
+		r.sendError(rw, review, gv, fmt.Errorf("request is not set"))
 		return
 	}
 
@@ -62,26 +117,122 @@ func (r *Router) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 
 	review.Response = &response.AdmissionResponse
 
-	if err := r.admit(response, review.Request, req); err != nil {
-		r.sendError(rw, review, err)
+	if err := r.admit(response, review.Request, req, filter); err != nil {
+		r.sendError(rw, review, gv, err)
 		return
 	}
 
-	writeResponse(rw, review)
+	writeResponse(rw, review, gv)
 }
 
-func (r *Router) admit(response *Response, request *v1.AdmissionRequest, req *http.Request) error {
+func (r *Router) admit(response *Response, request *v1.AdmissionRequest, req *http.Request, filter func(*RouteMatch) bool) error {
+	var (
+		matched  bool
+		errs     []error
+		denials  []*metav1.Status
+		original = request.Object.Raw
+		admitReq = &Request{
+			AdmissionRequest: *request,
+			Context:          req.Context(),
+		}
+	)
+
 	for _, m := range r.matches {
-		if m.matches(request) {
-			err := m.admit(response, &Request{
-				AdmissionRequest: *request,
-				Context:          req.Context(),
-			})
-			log.Debugf("admit result: %s %s %s user=%s allowed=%v err=%v", request.Operation, request.Kind.String(), resourceString(request.Namespace, request.Name), request.UserInfo.Username, response.Allowed, err)
-			return err
+		if !filter(m) || !m.matches(request) {
+			continue
+		}
+		if ok, err := m.matchesSelectors(req.Context(), r.client, request); err != nil {
+			return fmt.Errorf("failed to match selectors for %s %s: %v", request.Kind.String(), resourceString(request.Namespace, request.Name), err)
+		} else if !ok {
+			continue
+		}
+		matched = true
+
+		routeResponse := &Response{
+			AdmissionResponse: v1.AdmissionResponse{
+				UID: request.UID,
+			},
+		}
+		err := m.admit(routeResponse, admitReq, r.middleware)
+		log.Debugf("admit result: %s %s %s user=%s allowed=%v err=%v", request.Operation, request.Kind.String(), resourceString(request.Namespace, request.Name), request.UserInfo.Username, routeResponse.Allowed, err)
+
+		response.Warnings = append(response.Warnings, routeResponse.Warnings...)
+		for k, v := range routeResponse.AuditAnnotations {
+			if response.AuditAnnotations == nil {
+				response.AuditAnnotations = map[string]string{}
+			}
+			response.AuditAnnotations[k] = v
+		}
+		if status, ok := denialStatus(request, err); ok {
+			log.Debugf("denied: %v", err)
+			denials = append(denials, status)
+		} else if err != nil {
+			errs = append(errs, err)
+		} else if !routeResponse.Allowed {
+			denials = append(denials, routeResponse.Result)
+		}
+
+		if !r.matchAll || len(errs) > 0 {
+			break
 		}
 	}
-	return fmt.Errorf("no route match found for %s %s %s", request.Operation, request.Kind.String(), resourceString(request.Namespace, request.Name))
+
+	if !matched {
+		if ptr.Deref(r.defaultAllow, false) {
+			response.Allowed = true
+			return nil
+		}
+		return fmt.Errorf("no route match found for %s %s %s", request.Operation, request.Kind.String(), resourceString(request.Namespace, request.Name))
+	}
+
+	if len(errs) > 0 {
+		return merr.NewErrors(errs...)
+	}
+	if len(denials) > 0 {
+		response.Allowed = false
+		response.Result = mergeStatus(denials)
+		return nil
+	}
+
+	response.Allowed = true
+	if bytes.Equal(original, admitReq.Object.Raw) {
+		return nil
+	}
+	patch, err := createPatch(original, admitReq.Object.Raw)
+	if err != nil {
+		return err
+	}
+	response.Patch, response.PatchType = patch, &jsonPatchType
+	return nil
+}
+
+// mergeStatus combines the statuses of the routes that denied a request. The code and reason are those of the first
+// denial, while the messages and causes of all are kept.
+func mergeStatus(statuses []*metav1.Status) *metav1.Status {
+	var (
+		result   *metav1.Status
+		messages []string
+	)
+	for _, status := range statuses {
+		if status == nil {
+			continue
+		}
+		if result == nil {
+			result = status.DeepCopy()
+		} else if status.Details != nil {
+			if result.Details == nil {
+				result.Details = &metav1.StatusDetails{}
+			}
+			result.Details.Causes = append(result.Details.Causes, status.Details.Causes...)
+		}
+		if status.Message != "" {
+			messages = append(messages, status.Message)
+		}
+	}
+	if result != nil {
+		result.Message = strings.Join(messages, "; ")
+	}
+	return result
 }
 
 func (r *Router) next() *RouteMatch {
@@ -108,6 +259,27 @@ type Response struct {
 	v1.AdmissionResponse
 }
 
+// Warn adds a warning that is returned to the user whether the request is allowed or denied.
+func (r *Response) Warn(warning string) {
+	r.Warnings = append(r.Warnings, warning)
+}
+
+// Warnf adds a formatted warning that is returned to the user whether the request is allowed or denied.
+func (r *Response) Warnf(format string, args ...any) {
+	r.Warn(fmt.Sprintf(format, args...))
+}
+
+// AddAuditAnnotation adds an annotation to the audit event of the request. The API server prefixes the key with the
+// name of the webhook, so the key must be a valid annotation name without a prefix.
+func (r *Response) AddAuditAnnotation(key, value string) {
+	if r.AuditAnnotations == nil {
+		r.AuditAnnotations = map[string]string{}
+	}
+	r.AuditAnnotations[key] = value
+}
+
+// CreatePatch sets the patch of the response to the changes from the object of the request to newObj. When a route
+// has more than one handler, each handler may create a patch and the patches are combined.
 func (r *Response) CreatePatch(request *Request, newObj kclient.Object) error {
 	if len(r.Patch) > 0 {
 		return fmt.Errorf("response patch has already been already been assigned")
@@ -118,12 +290,7 @@ func (r *Response) CreatePatch(request *Request, newObj kclient.Object) error {
 		return err
 	}
 
-	patch, err := jsonpatch.CreatePatch(request.Object.Raw, newBytes)
-	if err != nil {
-		return err
-	}
-
-	patchData, err := json.Marshal(patch)
+	patchData, err := createPatch(request.Object.Raw, newBytes)
 	if err != nil {
 		return err
 	}
@@ -133,10 +300,20 @@ func (r *Response) CreatePatch(request *Request, newObj kclient.Object) error {
 	return nil
 }
 
+func createPatch(original, modified []byte) ([]byte, error) {
+	patch, err := jsonpatch.CreatePatch(original, modified)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(patch)
+}
+
 type Handler interface {
 	Admit(resp *Response, req *Request) error
 }
 
+type Middleware func(h Handler) Handler
+
 type HandlerFunc func(resp *Response, req *Request) error
 
 func (h HandlerFunc) Admit(resp *Response, req *Request) error {
@@ -0,0 +1,479 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/acorn-io/baaah/pkg/log"
+	"github.com/acorn-io/baaah/pkg/merr"
+	"github.com/acorn-io/baaah/pkg/router"
+	"github.com/acorn-io/baaah/pkg/uncached"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/utils/ptr"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	ValidatePath = "/validate"
+	MutatePath   = "/mutate"
+	ConvertPath  = "/convert"
+
+	defaultPort           = 8443
+	defaultServicePort    = 443
+	defaultReloadInterval = time.Minute
+)
+
+type ServerOptions struct {
+	// Name is the name of the webhook configurations. Required.
+	Name string
+	// Namespace is the namespace of the Service in front of the webhook server and of the Secret storing its
+	// certificates. Required.
+	Namespace string
+	// ServiceName defaults to Name.
+	ServiceName string
+	// ServicePort defaults to 443.
+	ServicePort int32
+	// SecretName defaults to Name with the suffix "-webhook-tls".
+	SecretName string
+	// Port is the port the webhook server listens on. Defaults to 8443.
+	Port int
+	// FailurePolicy defaults to Fail.
+	FailurePolicy *admissionregistrationv1.FailurePolicyType
+	// ReloadInterval is how often every replica reads the Secret to load rotated certificates. Defaults to 1 minute.
+	ReloadInterval time.Duration
+	// Converter serves the conversion of custom resources at ConvertPath if set.
+	Converter *Converter
+	// ConversionCRDs are the names of the CustomResourceDefinitions whose conversion webhook is set to this server. The
+	// scheme of the baaah Router must then include the apiextensions v1 types.
+	ConversionCRDs []string
+}
+
+func (o ServerOptions) complete() (ServerOptions, error) {
+	if o.Name == "" || o.Namespace == "" {
+		return o, fmt.Errorf("name and namespace of the webhook server are required")
+	}
+	if o.ServiceName == "" {
+		o.ServiceName = o.Name
+	}
+	if o.ServicePort == 0 {
+		o.ServicePort = defaultServicePort
+	}
+	if o.SecretName == "" {
+		o.SecretName = o.Name + "-webhook-tls"
+	}
+	if o.Port == 0 {
+		o.Port = defaultPort
+	}
+	if o.FailurePolicy == nil {
+		o.FailurePolicy = ptr.To(admissionregistrationv1.Fail)
+	}
+	if o.ReloadInterval == 0 {
+		o.ReloadInterval = defaultReloadInterval
+	}
+	return o, nil
+}
+
+// Server serves a webhook Router over TLS. The CA and serving certificate are generated, rotated, and stored in a
+// Secret, and the webhook configurations are kept up to date with the routes of the webhook Router by handlers added
+// to a baaah Router. The scheme of the baaah Router must include the core and admissionregistration v1 types.
+//
+// The rules of the webhook configurations cannot express every condition of a route, such as a name or dry run, so
+// the webhook Router allows requests that match no route unless SetDefaultAllow(false) was called on it.
+type Server struct {
+	webhooks *Router
+	opts     ServerOptions
+	client   kclient.Client
+
+	lock    sync.RWMutex
+	certPEM []byte
+	cert    *tls.Certificate
+}
+
+// NewServer returns a server for the webhooks and adds the handlers that manage its certificates and webhook
+// configurations to r. Routes must not be added to webhooks after r is started. Requests that match no route are
+// allowed unless the default was already set with webhooks.SetDefaultAllow.
+func NewServer(webhooks *Router, r *router.Router, opts ServerOptions) (*Server, error) {
+	opts, err := opts.complete()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		webhooks: webhooks,
+		opts:     opts,
+		client:   r.Backend(),
+	}
+	if webhooks.client == nil {
+		webhooks.SetClient(r.Backend())
+	}
+	if webhooks.defaultAllow == nil {
+		webhooks.SetDefaultAllow(true)
+	}
+
+	r.Type(&corev1.Secret{}).Namespace(opts.Namespace).Name(opts.SecretName).IncludeRemoved().HandlerFunc(s.reconcile)
+	return s, nil
+}
+
+// Start creates the Secret if it does not exist and serves the webhooks until ctx is canceled. This is run on every
+// replica, while only the leader of the baaah Router rotates the certificates and applies the webhook configurations.
+// Every replica reads the Secret at ReloadInterval to load rotated certificates, which the leader also loads as soon
+// as it rotates them.
+func (s *Server) Start(ctx context.Context) error {
+	secret, err := s.getOrCreateSecret(ctx)
+	if err != nil {
+		return err
+	}
+	if err := s.load(secret); err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(ValidatePath, s.webhooks.ValidatingHandler())
+	mux.Handle(MutatePath, s.webhooks.MutatingHandler())
+	if s.opts.Converter != nil {
+		mux.Handle(ConvertPath, s.opts.Converter)
+	}
+
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", s.opts.Port),
+		Handler: mux,
+		TLSConfig: &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: s.getCertificate,
+		},
+	}
+
+	go s.reload(ctx)
+	go func() {
+		<-ctx.Done()
+		if err := srv.Shutdown(context.WithoutCancel(ctx)); err != nil {
+			log.Warnf("error shutting down webhook server: %v", err)
+		}
+	}()
+	go func() {
+		log.Infof("webhook server stopped: %v", srv.ListenAndServeTLS("", ""))
+	}()
+	return nil
+}
+
+func (s *Server) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.cert, nil
+}
+
+// load replaces the serving certificate if the one in the Secret has changed.
+func (s *Server) load(secret *corev1.Secret) error {
+	certPEM := secret.Data[corev1.TLSCertKey]
+
+	s.lock.RLock()
+	unchanged := bytes.Equal(s.certPEM, certPEM)
+	s.lock.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return fmt.Errorf("invalid serving certificate in secret %s/%s: %w", secret.Namespace, secret.Name, err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.certPEM != nil {
+		log.Infof("Reloaded webhook serving certificate from secret %s/%s", secret.Namespace, secret.Name)
+	}
+	s.certPEM, s.cert = certPEM, &cert
+	return nil
+}
+
+func (s *Server) reload(ctx context.Context) {
+	ticker := time.NewTicker(s.opts.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		secret := &corev1.Secret{}
+		if err := s.client.Get(ctx, router.Key(s.opts.Namespace, s.opts.SecretName), uncached.Get(secret)); err != nil {
+			log.Errorf("failed to reload webhook certificates: %v", err)
+		} else if err := s.load(secret); err != nil {
+			log.Errorf("failed to reload webhook certificates: %v", err)
+		}
+	}
+}
+
+func (s *Server) getOrCreateSecret(ctx context.Context) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	err := s.client.Get(ctx, router.Key(s.opts.Namespace, s.opts.SecretName), uncached.Get(secret))
+	if err == nil {
+		return secret, nil
+	} else if !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	secret, err = s.newSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.client.Create(ctx, secret); apierrors.IsAlreadyExists(err) {
+		// Another replica created the Secret first.
+		secret = &corev1.Secret{}
+		return secret, s.client.Get(ctx, router.Key(s.opts.Namespace, s.opts.SecretName), uncached.Get(secret))
+	} else if err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+func (s *Server) newSecret() (*corev1.Secret, error) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.opts.SecretName,
+			Namespace: s.opts.Namespace,
+		},
+	}
+	_, err := ensureCerts(secret, s.opts.ServiceName, s.dnsNames(), time.Now())
+	return secret, err
+}
+
+func (s *Server) dnsNames() []string {
+	return []string{
+		fmt.Sprintf("%s.%s.svc", s.opts.ServiceName, s.opts.Namespace),
+		fmt.Sprintf("%s.%s.svc.cluster.local", s.opts.ServiceName, s.opts.Namespace),
+		fmt.Sprintf("%s.%s", s.opts.ServiceName, s.opts.Namespace),
+	}
+}
+
+// reconcile rotates the certificates in the Secret, loads them, and applies the webhook configurations.
+func (s *Server) reconcile(req router.Request, resp router.Response) error {
+	if req.Object == nil {
+		secret, err := s.newSecret()
+		if err != nil {
+			return err
+		}
+		if err := req.Client.Create(req.Ctx, secret); err != nil && !apierrors.IsAlreadyExists(err) {
+			return err
+		}
+		return nil
+	}
+
+	secret := req.Object.(*corev1.Secret)
+	if changed, err := ensureCerts(secret, s.opts.ServiceName, s.dnsNames(), time.Now()); err != nil {
+		return err
+	} else if changed {
+		log.Infof("Rotating webhook certificates in secret %s/%s", secret.Namespace, secret.Name)
+		// The update is handled next.
+		return req.Client.Update(req.Ctx, secret)
+	}
+	if err := s.load(secret); err != nil {
+		return err
+	}
+
+	// Get the webhook configurations so that changes made to them by others are reverted.
+	for _, obj := range []kclient.Object{&admissionregistrationv1.ValidatingWebhookConfiguration{}, &admissionregistrationv1.MutatingWebhookConfiguration{}} {
+		if err := req.Get(obj, "", s.opts.Name); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	objs, err := s.webhookConfigurations(req.Client.RESTMapper(), secret.Data[CACertKey])
+	if err != nil {
+		return err
+	}
+	resp.Objects(objs...)
+
+	if err := s.updateConversion(req, secret.Data[CACertKey]); err != nil {
+		return err
+	}
+	resp.RetryAfter(time.Until(nextRotation(secret)))
+	return nil
+}
+
+type webhookGroup struct {
+	objectSelector    *metav1.LabelSelector
+	namespaceSelector *metav1.LabelSelector
+	rules             []admissionregistrationv1.RuleWithOperations
+}
+
+// webhookConfigurations returns the configurations for the validating and mutating routes. A configuration is only
+// returned if there are routes of its type. Each configuration has a webhook for every distinct pair of object and
+// namespace selectors of its routes.
+func (s *Server) webhookConfigurations(mapper meta.RESTMapper, caBundle []byte) ([]kclient.Object, error) {
+	var (
+		validating, mutating []*webhookGroup
+		groups               = map[string]*webhookGroup{}
+		errs                 []error
+	)
+	for _, m := range s.webhooks.matches {
+		if len(m.handlers) == 0 {
+			continue
+		}
+		rule, err := m.rule(mapper)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		key := fmt.Sprintf("%v/%s/%s", m.mutating, selectorString(m.selector), selectorString(m.nsSelector))
+		group, ok := groups[key]
+		if !ok {
+			group = &webhookGroup{}
+			if group.objectSelector, err = toLabelSelector(m.selector); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if group.namespaceSelector, err = toLabelSelector(m.nsSelector); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			groups[key] = group
+			if m.mutating {
+				mutating = append(mutating, group)
+			} else {
+				validating = append(validating, group)
+			}
+		}
+		group.rules = append(group.rules, rule)
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("failed to build webhook rules: %w", merr.NewErrors(errs...))
+	}
+
+	var result []kclient.Object
+	if len(validating) > 0 {
+		config := &admissionregistrationv1.ValidatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: s.opts.Name,
+			},
+		}
+		for i, group := range validating {
+			config.Webhooks = append(config.Webhooks, admissionregistrationv1.ValidatingWebhook{
+				Name:                    s.webhookName("validate", i),
+				ClientConfig:            s.clientConfig(ValidatePath, caBundle),
+				Rules:                   group.rules,
+				FailurePolicy:           s.opts.FailurePolicy,
+				ObjectSelector:          group.objectSelector,
+				NamespaceSelector:       group.namespaceSelector,
+				SideEffects:             ptr.To(admissionregistrationv1.SideEffectClassNone),
+				AdmissionReviewVersions: reviewVersions,
+			})
+		}
+		result = append(result, config)
+	}
+	if len(mutating) > 0 {
+		config := &admissionregistrationv1.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: s.opts.Name,
+			},
+		}
+		for i, group := range mutating {
+			config.Webhooks = append(config.Webhooks, admissionregistrationv1.MutatingWebhook{
+				Name:                    s.webhookName("mutate", i),
+				ClientConfig:            s.clientConfig(MutatePath, caBundle),
+				Rules:                   group.rules,
+				FailurePolicy:           s.opts.FailurePolicy,
+				ObjectSelector:          group.objectSelector,
+				NamespaceSelector:       group.namespaceSelector,
+				SideEffects:             ptr.To(admissionregistrationv1.SideEffectClassNone),
+				AdmissionReviewVersions: reviewVersions,
+				ReinvocationPolicy:      ptr.To(admissionregistrationv1.IfNeededReinvocationPolicy),
+			})
+		}
+		result = append(result, config)
+	}
+	return result, nil
+}
+
+func selectorString(sel labels.Selector) string {
+	if sel == nil {
+		return ""
+	}
+	return sel.String()
+}
+
+// toLabelSelector returns the label selector of a webhook for the selector of a route, or nil to match everything.
+func toLabelSelector(sel labels.Selector) (*metav1.LabelSelector, error) {
+	if sel == nil || sel.Empty() {
+		return nil, nil
+	}
+	return metav1.ParseToLabelSelector(sel.String())
+}
+
+// updateConversion sets the conversion webhook of the CRDs to this server. The CRDs are not created by the server, so
+// they are updated instead of applied, and CRDs that do not exist yet are skipped.
+func (s *Server) updateConversion(req router.Request, caBundle []byte) error {
+	if s.opts.Converter == nil {
+		return nil
+	}
+
+	conversion := &apiextensionsv1.CustomResourceConversion{
+		Strategy: apiextensionsv1.WebhookConverter,
+		Webhook: &apiextensionsv1.WebhookConversion{
+			ClientConfig: &apiextensionsv1.WebhookClientConfig{
+				Service: &apiextensionsv1.ServiceReference{
+					Namespace: s.opts.Namespace,
+					Name:      s.opts.ServiceName,
+					Path:      ptr.To(ConvertPath),
+					Port:      ptr.To(s.opts.ServicePort),
+				},
+				CABundle: caBundle,
+			},
+			ConversionReviewVersions: []string{"v1"},
+		},
+	}
+
+	var errs []error
+	for _, name := range s.opts.ConversionCRDs {
+		crd := &apiextensionsv1.CustomResourceDefinition{}
+		if err := req.Get(crd, "", name); apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if equality.Semantic.DeepEqual(crd.Spec.Conversion, conversion) {
+			continue
+		}
+		crd.Spec.Conversion = conversion
+		if err := req.Client.Update(req.Ctx, crd); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return merr.NewErrors(errs...)
+}
+
+// webhookName returns a fully qualified name for a webhook as required by the API server.
+func (s *Server) webhookName(prefix string, index int) string {
+	if index > 0 {
+		prefix = fmt.Sprintf("%s-%d", prefix, index)
+	}
+	return fmt.Sprintf("%s.%s.%s.svc", prefix, s.opts.ServiceName, s.opts.Namespace)
+}
+
+func (s *Server) clientConfig(path string, caBundle []byte) admissionregistrationv1.WebhookClientConfig {
+	return admissionregistrationv1.WebhookClientConfig{
+		Service: &admissionregistrationv1.ServiceReference{
+			Namespace: s.opts.Namespace,
+			Name:      s.opts.ServiceName,
+			Path:      ptr.To(path),
+			Port:      ptr.To(s.opts.ServicePort),
+		},
+		CABundle: caBundle,
+	}
+}
@@ -0,0 +1,317 @@
+package tester
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/acorn-io/baaah/pkg/webhook"
+	"github.com/acorn-io/baaah/pkg/yaml"
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	yaml2 "sigs.k8s.io/yaml"
+)
+
+// Status is the expected result of a request. It is read from expected-status.yaml by FromDir. The code, reason,
+// message, and warnings are only compared if they are set.
+type Status struct {
+	Allowed  bool                `json:"allowed"`
+	Code     int32               `json:"code,omitempty"`
+	Reason   metav1.StatusReason `json:"reason,omitempty"`
+	Message  string              `json:"message,omitempty"`
+	Warnings []string            `json:"warnings,omitempty"`
+}
+
+type Harness struct {
+	Scheme *runtime.Scheme
+	// Old is the object before the request. It is nil on create.
+	Old kclient.Object
+	// Operation defaults to CREATE if there is no old object, DELETE if there is no input, and UPDATE otherwise.
+	Operation admissionv1.Operation
+	UserInfo  authenticationv1.UserInfo
+	// ExpectedOutput is the object after the patch of the response is applied. It is not compared if nil.
+	ExpectedOutput kclient.Object
+	// ExpectedStatus is the expected result. If nil, the request is expected to be allowed.
+	ExpectedStatus *Status
+}
+
+type Response struct {
+	admissionv1.AdmissionResponse
+	// Object is the input with the patch of the response applied, or nil if there is no input.
+	Object kclient.Object
+}
+
+// readObject returns the object in the file, or nil if the file does not exist.
+func readObject(scheme *runtime.Scheme, dir, file string) (kclient.Object, error) {
+	path := filepath.Join(dir, file)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	objs, err := yaml.ToObjects(bytes.NewBuffer(data))
+	if err != nil {
+		return nil, fmt.Errorf("unmarshalling %s: %w", path, err)
+	}
+	if len(objs) != 1 {
+		return nil, fmt.Errorf("%s does not include one object", path)
+	}
+	typedObjs, err := yaml.ToTypedObjects(scheme, objs)
+	if err != nil {
+		return nil, err
+	}
+	return typedObjs[0], nil
+}
+
+// FromDir reads a test case from the directory. The directory has the object of the request in input.yaml, and
+// optionally the object before the request in old.yaml, the object after the patch of the response is applied in
+// expected.yaml, and the expected result in expected-status.yaml. Either input.yaml or old.yaml must exist.
+func FromDir(scheme *runtime.Scheme, path string) (*Harness, kclient.Object, error) {
+	input, err := readObject(scheme, path, "input.yaml")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	old, err := readObject(scheme, path, "old.yaml")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if input == nil && old == nil {
+		return nil, nil, fmt.Errorf("%s does not include input.yaml or old.yaml", path)
+	}
+
+	expected, err := readObject(scheme, path, "expected.yaml")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var status *Status
+	data, err := os.ReadFile(filepath.Join(path, "expected-status.yaml"))
+	if err == nil {
+		status = &Status{}
+		if err := yaml2.Unmarshal(data, status); err != nil {
+			return nil, nil, fmt.Errorf("unmarshalling %s/expected-status.yaml: %w", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+
+	return &Harness{
+		Scheme:         scheme,
+		Old:            old,
+		ExpectedOutput: expected,
+		ExpectedStatus: status,
+	}, input, nil
+}
+
+func DefaultTest(t *testing.T, scheme *runtime.Scheme, path string, router *webhook.Router) (result *Response) {
+	t.Helper()
+	t.Run(path, func(t *testing.T) {
+		harness, input, err := FromDir(scheme, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err = harness.Invoke(t, input, router)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	return
+}
+
+// NewReview returns an admission review for the operation on the objects. Either old or input may be nil.
+func NewReview(t *testing.T, scheme *runtime.Scheme, operation admissionv1.Operation, old, input kclient.Object, userInfo authenticationv1.UserInfo) *admissionv1.AdmissionReview {
+	t.Helper()
+	obj := input
+	if obj == nil {
+		obj = old
+	}
+	gvk, err := apiutil.GVKForObject(obj, scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gvr, _ := meta.UnsafeGuessKindToResource(gvk)
+	kind := metav1.GroupVersionKind{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind}
+	resource := metav1.GroupVersionResource{Group: gvr.Group, Version: gvr.Version, Resource: gvr.Resource}
+
+	return &admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: admissionv1.SchemeGroupVersion.String(),
+			Kind:       "AdmissionReview",
+		},
+		Request: &admissionv1.AdmissionRequest{
+			UID:             types.UID("test"),
+			Kind:            kind,
+			Resource:        resource,
+			RequestKind:     &kind,
+			RequestResource: &resource,
+			Name:            obj.GetName(),
+			Namespace:       obj.GetNamespace(),
+			Operation:       operation,
+			UserInfo:        userInfo,
+			Object:          rawObject(t, scheme, input),
+			OldObject:       rawObject(t, scheme, old),
+		},
+	}
+}
+
+func rawObject(t *testing.T, scheme *runtime.Scheme, obj kclient.Object) runtime.RawExtension {
+	t.Helper()
+	if obj == nil {
+		return runtime.RawExtension{}
+	}
+	obj = obj.DeepCopyObject().(kclient.Object)
+	gvk, err := apiutil.GVKForObject(obj, scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+	data, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return runtime.RawExtension{Raw: data}
+}
+
+// Review sends the review to the router as the API server would and returns the response.
+func Review(t *testing.T, router http.Handler, review *admissionv1.AdmissionReview) (*admissionv1.AdmissionResponse, error) {
+	t.Helper()
+	data, err := json.Marshal(review)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+	if rec.Code != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d: %s", rec.Code, rec.Body.String())
+	}
+
+	result := &admissionv1.AdmissionReview{}
+	if err := json.NewDecoder(rec.Body).Decode(result); err != nil {
+		return nil, err
+	}
+	if result.Response == nil {
+		return nil, fmt.Errorf("response is not set")
+	}
+	return result.Response, nil
+}
+
+func (b *Harness) operation(input kclient.Object) admissionv1.Operation {
+	switch {
+	case b.Operation != "":
+		return b.Operation
+	case b.Old == nil:
+		return admissionv1.Create
+	case input == nil:
+		return admissionv1.Delete
+	default:
+		return admissionv1.Update
+	}
+}
+
+// Invoke runs a request for the input through the router, applies the patch of the response to the input, and checks
+// the result against the expected output and status.
+func (b *Harness) Invoke(t *testing.T, input kclient.Object, router *webhook.Router) (*Response, error) {
+	t.Helper()
+	review := NewReview(t, b.Scheme, b.operation(input), b.Old, input, b.UserInfo)
+	admissionResponse, err := Review(t, router, review)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &Response{
+		AdmissionResponse: *admissionResponse,
+	}
+	if input != nil {
+		resp.Object, err = applyPatch(b.Scheme, review.Request.Object.Raw, input, admissionResponse.Patch)
+		if err != nil {
+			return resp, err
+		}
+	}
+
+	b.checkStatus(t, resp)
+
+	if b.ExpectedOutput != nil && resp.Object != nil {
+		gvk, err := apiutil.GVKForObject(b.ExpectedOutput, b.Scheme)
+		if err != nil {
+			return resp, err
+		}
+		b.ExpectedOutput.GetObjectKind().SetGroupVersionKind(gvk)
+
+		left, _ := yaml2.Marshal(b.ExpectedOutput)
+		right, _ := yaml2.Marshal(resp.Object)
+		assert.Equal(t, string(left), string(right), "patched object %s/%s (%v) does not match", resp.Object.GetNamespace(), resp.Object.GetName(), gvk)
+	}
+
+	return resp, nil
+}
+
+func (b *Harness) checkStatus(t *testing.T, resp *Response) {
+	t.Helper()
+	expected := b.ExpectedStatus
+	if expected == nil {
+		assert.Truef(t, resp.Allowed, "request was denied: %v", resp.Result)
+		return
+	}
+
+	assert.Equal(t, expected.Allowed, resp.Allowed, "allowed does not match: %v", resp.Result)
+	result := resp.Result
+	if result == nil {
+		result = &metav1.Status{}
+	}
+	if expected.Code != 0 {
+		assert.Equal(t, expected.Code, result.Code, "status code does not match")
+	}
+	if expected.Reason != "" {
+		assert.Equal(t, expected.Reason, result.Reason, "status reason does not match")
+	}
+	if expected.Message != "" {
+		assert.Equal(t, expected.Message, result.Message, "status message does not match")
+	}
+	if expected.Warnings != nil {
+		assert.Equal(t, expected.Warnings, resp.Warnings, "warnings do not match")
+	}
+}
+
+// applyPatch returns a copy of the input with the patch applied.
+func applyPatch(scheme *runtime.Scheme, raw []byte, input kclient.Object, patchData []byte) (kclient.Object, error) {
+	if len(patchData) > 0 {
+		patch, err := jsonpatch.DecodePatch(patchData)
+		if err != nil {
+			return nil, err
+		}
+		if raw, err = patch.Apply(raw); err != nil {
+			return nil, err
+		}
+	}
+
+	gvk, err := apiutil.GVKForObject(input, scheme)
+	if err != nil {
+		return nil, err
+	}
+	result, err := scheme.New(gvk)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, result); err != nil {
+		return nil, err
+	}
+	result.GetObjectKind().SetGroupVersionKind(gvk)
+	return result.(kclient.Object), nil
+}
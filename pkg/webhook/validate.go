@@ -0,0 +1,119 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/acorn-io/baaah/pkg/typed"
+	v1 "k8s.io/api/admission/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/utils/ptr"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ValidateFunc validates a request with its objects decoded. The old object is nil on create and the new object is
+// nil on delete. Returning field errors denies the request as invalid, while returning an error is an internal failure
+// unless it is returned by Deny or apierrors.NewInvalid.
+type ValidateFunc[T kclient.Object] func(req *Request, resp *Response, oldObj, newObj T) (field.ErrorList, error)
+
+// Validate returns a handler that decodes the objects of the request as T, which must be a pointer to a struct, and
+// allows the request if fn returns no errors.
+func Validate[T kclient.Object](fn ValidateFunc[T]) Handler {
+	return HandlerFunc(func(resp *Response, req *Request) error {
+		var oldObj, newObj T
+		if len(req.OldObject.Raw) > 0 {
+			oldObj = typed.New[T]()
+			if err := req.DecodeOldObject(oldObj); err != nil {
+				return err
+			}
+		}
+		if len(req.Object.Raw) > 0 {
+			newObj = typed.New[T]()
+			if err := req.DecodeObject(newObj); err != nil {
+				return err
+			}
+		}
+
+		errs, err := fn(req, resp, oldObj, newObj)
+		if err != nil {
+			return err
+		}
+		if len(errs) > 0 {
+			name := req.Name
+			if name == "" && len(req.Object.Raw) > 0 {
+				name = newObj.GetName()
+			}
+			return apierrors.NewInvalid(schema.GroupKind{Group: req.Kind.Group, Kind: req.Kind.Kind}, name, errs)
+		}
+
+		resp.Allowed = true
+		return nil
+	})
+}
+
+// Deny returns an error that denies a request with the message. Unlike other errors returned by handlers, it is not
+// logged as a failure of the webhook.
+func Deny(format string, args ...any) error {
+	return &denyError{
+		StatusError: &apierrors.StatusError{
+			ErrStatus: metav1.Status{
+				Status:  metav1.StatusFailure,
+				Code:    http.StatusForbidden,
+				Reason:  metav1.StatusReasonForbidden,
+				Message: fmt.Sprintf(format, args...),
+			},
+		},
+	}
+}
+
+// denyError is returned by Deny, so that it can be told apart from API errors returned by clients.
+type denyError struct {
+	*apierrors.StatusError
+}
+
+// denialStatus returns the status of an error that denies the request: an error returned by Deny, an invalid error
+// such as one returned by apierrors.NewInvalid, or field errors. Any other error, including errors returned by clients
+// such as NotFound or Conflict, is an internal failure.
+func denialStatus(req *v1.AdmissionRequest, err error) (*metav1.Status, bool) {
+	var deny *denyError
+	if errors.As(err, &deny) {
+		return ptr.To(deny.Status()), true
+	}
+	if apierrors.IsInvalid(err) {
+		var status apierrors.APIStatus
+		errors.As(err, &status)
+		return ptr.To(status.Status()), true
+	}
+	if errs := fieldErrors(err); len(errs) > 0 {
+		gk := schema.GroupKind{Group: req.Kind.Group, Kind: req.Kind.Kind}
+		return ptr.To(apierrors.NewInvalid(gk, req.Name, errs).Status()), true
+	}
+	return nil, false
+}
+
+// fieldErrors returns the field errors of err, which is either a field error or an aggregate of only field errors.
+func fieldErrors(err error) field.ErrorList {
+	var agg utilerrors.Aggregate
+	if errors.As(err, &agg) {
+		var result field.ErrorList
+		for _, err := range agg.Errors() {
+			errs := fieldErrors(err)
+			if len(errs) == 0 {
+				return nil
+			}
+			result = append(result, errs...)
+		}
+		return result
+	}
+
+	var fieldErr *field.Error
+	if errors.As(err, &fieldErr) {
+		return field.ErrorList{fieldErr}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	v1 "k8s.io/api/admission/v1"
+	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// reviewVersions are the versions of AdmissionReview that are accepted, in order of preference.
+var reviewVersions = []string{v1.SchemeGroupVersion.Version, v1beta1.SchemeGroupVersion.Version}
+
+// decodeReview decodes an AdmissionReview of any supported version as admission/v1 and returns the version used by
+// the request, so that the response can be sent in the same version. The request and response of admission/v1beta1
+// have the same fields as those of admission/v1.
+func decodeReview(data []byte) (*v1.AdmissionReview, schema.GroupVersion, error) {
+	typeMeta := metav1.TypeMeta{}
+	if err := json.Unmarshal(data, &typeMeta); err != nil {
+		return nil, v1.SchemeGroupVersion, err
+	}
+
+	gv := v1.SchemeGroupVersion
+	if typeMeta.APIVersion != "" {
+		var err error
+		if gv, err = schema.ParseGroupVersion(typeMeta.APIVersion); err != nil {
+			return nil, v1.SchemeGroupVersion, err
+		}
+	}
+	if gv != v1.SchemeGroupVersion && gv != v1beta1.SchemeGroupVersion {
+		return nil, v1.SchemeGroupVersion, fmt.Errorf("unsupported admission review version %s", typeMeta.APIVersion)
+	}
+
+	review := &v1.AdmissionReview{}
+	if err := json.Unmarshal(data, review); err != nil {
+		return nil, gv, err
+	}
+	return review, gv, nil
+}
+
+func writeResponse(rw http.ResponseWriter, review *v1.AdmissionReview, gv schema.GroupVersion) {
+	var result any = &v1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1.SchemeGroupVersion.String(),
+			Kind:       "AdmissionReview",
+		},
+		Response: review.Response,
+	}
+	if gv == v1beta1.SchemeGroupVersion {
+		result = toV1beta1(review)
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(rw).Encode(result)
+}
+
+func toV1beta1(review *v1.AdmissionReview) *v1beta1.AdmissionReview {
+	result := &v1beta1.AdmissionReview{}
+	result.SetGroupVersionKind(v1beta1.SchemeGroupVersion.WithKind("AdmissionReview"))
+	if resp := review.Response; resp != nil {
+		result.Response = &v1beta1.AdmissionResponse{
+			UID:              resp.UID,
+			Allowed:          resp.Allowed,
+			Result:           resp.Result,
+			Patch:            resp.Patch,
+			AuditAnnotations: resp.AuditAnnotations,
+			Warnings:         resp.Warnings,
+		}
+		if resp.PatchType != nil {
+			result.Response.PatchType = (*v1beta1.PatchType)(resp.PatchType)
+		}
+	}
+	return result
+}
@@ -12,6 +12,7 @@ import (
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	yamlDecoder "k8s.io/apimachinery/pkg/util/yaml"
+	kclient "sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 	"sigs.k8s.io/yaml"
 )
@@ -52,6 +53,28 @@ func ToObjects(in io.Reader) ([]runtime.Object, error) {
 	return result, nil
 }
 
+// ToTypedObjects converts objects, such as those returned by ToObjects, to the types registered in the scheme for
+// their kinds.
+func ToTypedObjects(scheme *runtime.Scheme, objs []runtime.Object) ([]kclient.Object, error) {
+	result := make([]kclient.Object, 0, len(objs))
+	for _, obj := range objs {
+		typedObj, err := scheme.New(obj.GetObjectKind().GroupVersionKind())
+		if err != nil {
+			return nil, err
+		}
+
+		bytes, err := json.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(bytes, typedObj); err != nil {
+			return nil, err
+		}
+		result = append(result, typedObj.(kclient.Object))
+	}
+	return result, nil
+}
+
 func toObjects(bytes []byte) ([]runtime.Object, error) {
 	bytes, err := yamlDecoder.ToJSON(bytes)
 	if err != nil {
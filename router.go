@@ -2,6 +2,7 @@ package baaah
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/acorn-io/baaah/pkg/backend"
 	"github.com/acorn-io/baaah/pkg/leader"
@@ -9,7 +10,9 @@ import (
 	"github.com/acorn-io/baaah/pkg/router"
 	bruntime "github.com/acorn-io/baaah/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/workqueue"
 )
 
 const defaultHealthzPort = 8888
@@ -23,13 +26,50 @@ type Options struct {
 	DefaultNamespace string
 	// If a Backend is provided, then this is ignored.
 	Scheme *runtime.Scheme
+	// If a Backend is provided, then this is ignored. CacheConfigs restrict and transform the objects cached for
+	// the given kinds. Kinds in groups that have an entry in APIGroupConfigs must be configured there instead.
+	CacheConfigs map[schema.GroupVersionKind]bruntime.CacheConfig
+	// If a Backend is provided, then this is ignored. DefaultWorkers is the number of workers processing each kind.
+	// Defaults to bruntime.DefaultThreadiness.
+	DefaultWorkers int
+	// If a Backend is provided, then this is ignored. KindWorkers overrides DefaultWorkers for the given kinds.
+	// Workers can also be changed while running with Router.SetWorkers.
+	KindWorkers map[schema.GroupVersionKind]int
+	// If a Backend is provided, then this is ignored. DefaultRateLimiter is used when re-enqueuing keys that failed.
+	DefaultRateLimiter workqueue.RateLimiter
+	// If a Backend is provided, then this is ignored. KindRateLimiter overrides DefaultRateLimiter for the given kinds.
+	KindRateLimiter map[schema.GroupVersionKind]workqueue.RateLimiter
+	// If a Backend is provided, then this is ignored. DefaultPriorityQueue configures the order in which keys are
+	// processed. By default, informer events are processed ahead of triggers, and both ahead of resyncs.
+	DefaultPriorityQueue bruntime.PriorityQueueOptions
+	// If a Backend is provided, then this is ignored. KindPriorityQueue overrides DefaultPriorityQueue for the given
+	// kinds.
+	KindPriorityQueue map[schema.GroupVersionKind]bruntime.PriorityQueueOptions
 	// APIGroupConfigs are keyed by an API group. This indicates to the router that all actions on this group should use the
 	// given Config. This is useful for routers that watch different objects on different API servers.
 	APIGroupConfigs map[string]bruntime.Config
 	// ElectionConfig being nil represents no leader election for the router.
 	ElectionConfig *leader.ElectionConfig
+	// ShardConfig spreads the keys of the router across replicas using a Lease per shard. If set, ElectionConfig is
+	// ignored.
+	ShardConfig *leader.ShardConfig
 	// Defaults to 8888
 	HealthzPort int
+	// UnwatchGracePeriod is how long a kind must go unused by handlers and triggers before its informer is stopped.
+	// Defaults to 5 minutes. A negative value disables stopping unused informers.
+	UnwatchGracePeriod time.Duration
+	// ShutdownTimeout is how long to wait for running handlers to finish when the router is stopped. Defaults to 30
+	// seconds.
+	ShutdownTimeout time.Duration
+	// ReconcileTimeout is the deadline of the context passed to handlers for each reconcile of a key. Defaults to 5
+	// minutes. A negative value disables the deadline.
+	ReconcileTimeout time.Duration
+	// HandlerTimeout is the deadline of the context passed to each invocation of a handler that does not set its own with
+	// RouteBuilder.Timeout. By default, handlers are only limited by ReconcileTimeout.
+	HandlerTimeout time.Duration
+	// StuckReconcileThreshold is how long a reconcile may run before it is logged with its goroutine stack. If all
+	// workers of a kind are stuck, /livez fails. Defaults to 10 minutes. A negative value disables the check.
+	StuckReconcileThreshold time.Duration
 }
 
 func (o *Options) complete() (*Options, error) {
@@ -46,6 +86,22 @@ func (o *Options) complete() (*Options, error) {
 		result.HealthzPort = defaultHealthzPort
 	}
 
+	if result.UnwatchGracePeriod == 0 {
+		result.UnwatchGracePeriod = router.DefaultUnwatchGracePeriod
+	}
+
+	if result.ShutdownTimeout == 0 {
+		result.ShutdownTimeout = router.DefaultShutdownTimeout
+	}
+
+	if result.ReconcileTimeout == 0 {
+		result.ReconcileTimeout = router.DefaultReconcileTimeout
+	}
+
+	if result.StuckReconcileThreshold == 0 {
+		result.StuckReconcileThreshold = router.DefaultStuckThreshold
+	}
+
 	if result.Backend != nil {
 		return &result, nil
 	}
@@ -58,7 +114,17 @@ func (o *Options) complete() (*Options, error) {
 		}
 	}
 
-	defaultConfig := bruntime.Config{Rest: result.DefaultRESTConfig, Namespace: result.DefaultNamespace}
+	defaultConfig := bruntime.Config{
+		Rest:                 result.DefaultRESTConfig,
+		Namespace:            result.DefaultNamespace,
+		ByGVK:                result.CacheConfigs,
+		DefaultWorkers:       result.DefaultWorkers,
+		KindWorkers:          result.KindWorkers,
+		DefaultRateLimiter:   result.DefaultRateLimiter,
+		KindRateLimiter:      result.KindRateLimiter,
+		DefaultPriorityQueue: result.DefaultPriorityQueue,
+		KindPriorityQueue:    result.KindPriorityQueue,
+	}
 	backend, err := bruntime.NewRuntimeWithConfigs(defaultConfig, result.APIGroupConfigs, result.Scheme)
 	if err != nil {
 		return nil, err
@@ -105,5 +171,13 @@ func NewRouter(handlerName string, opts *Options) (*router.Router, error) {
 	if err != nil {
 		return nil, err
 	}
-	return router.New(router.NewHandlerSet(handlerName, opts.Backend.Scheme(), opts.Backend), opts.ElectionConfig, opts.HealthzPort), nil
+	handlerSet := router.NewHandlerSet(handlerName, opts.Backend.Scheme(), opts.Backend)
+	handlerSet.SetUnwatchGracePeriod(opts.UnwatchGracePeriod)
+	handlerSet.SetShutdownTimeout(opts.ShutdownTimeout)
+	handlerSet.SetReconcileTimeout(opts.ReconcileTimeout)
+	handlerSet.SetHandlerTimeout(opts.HandlerTimeout)
+	handlerSet.SetStuckThreshold(opts.StuckReconcileThreshold)
+	r := router.New(handlerSet, opts.ElectionConfig, opts.HealthzPort)
+	r.SetShardConfig(opts.ShardConfig)
+	return r, nil
 }